@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/wayjam/tvbox-mixproxy/config"
+	"github.com/wayjam/tvbox-mixproxy/pkg/diff"
+	"github.com/wayjam/tvbox-mixproxy/pkg/mixer"
+)
+
+func newDiffCmd(opts *rootOptions) *cobra.Command {
+	var (
+		mixed  string
+		asJSON bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "diff <old> [new]",
+		Short: "Show sites, lives and spider changes between two configs",
+		Long: `Compare two source snapshots or mixed configs semantically.
+Each argument is a URL, a source name defined in the config or a file path.
+A source name may be followed by @<version> to use a version kept in the
+history under cache_dir, where the version is a hash or a prefix of it.
+With --mixed, the single argument is compared against the freshly mixed config.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if (mixed == "") != (len(args) == 2) {
+				return fmt.Errorf("diff requires two arguments, or one argument with --mixed")
+			}
+
+			// 参数不是 URL 时可能是源名称, 需要加载配置; 只有像文件路径的参数时配置可以不存在
+			var cfg *config.Config
+			needConfig, requireConfig := mixed != "", mixed != ""
+			for _, arg := range args {
+				if !strings.Contains(arg, "://") {
+					needConfig = true
+					requireConfig = requireConfig || !strings.ContainsAny(arg, `/\.`)
+				}
+			}
+			if needConfig {
+				var err error
+				cfg, err = opts.load(config.NewLoader(opts.cfgFile))
+				if err != nil && requireConfig {
+					return fmt.Errorf("failed to load config: %w", err)
+				}
+			}
+
+			old, err := loadDiffTarget(cfg, args[0])
+			if err != nil {
+				return err
+			}
+
+			var new []byte
+			if mixed != "" {
+				sourceManager := newSourceManager(cfg)
+				defer sourceManager.Close()
+
+				result, _, err := mixProfile(cfg, sourceManager, mixed)
+				if err != nil {
+					return err
+				}
+				if new, err = json.Marshal(result); err != nil {
+					return fmt.Errorf("failed to encode %s repo: %w", mixed, err)
+				}
+			} else if new, err = loadDiffTarget(cfg, args[1]); err != nil {
+				return err
+			}
+
+			result, err := diff.Data(old, new)
+			if err != nil {
+				return err
+			}
+
+			if asJSON {
+				data, err := json.MarshalIndent(result, "", "  ")
+				if err != nil {
+					return err
+				}
+				return writeOutput("-", cmd.OutOrStdout(), data)
+			}
+			fmt.Fprint(cmd.OutOrStdout(), result.String())
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&mixed, "mixed", "", "compare against the freshly mixed config of this profile: single or multi")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "output the diff as JSON")
+
+	return cmd
+}
+
+// loadDiffTarget 加载 URL、源名称 (可带有 @版本) 或文件路径对应的数据, 配置中的源名称优先于同名的文件
+func loadDiffTarget(cfg *config.Config, arg string) ([]byte, error) {
+	uri := arg
+	if !strings.Contains(arg, "://") {
+		source, version, ok := findDiffSource(cfg, arg)
+		switch {
+		case ok && version != "":
+			return loadSourceVersion(cfg, source, version)
+		case ok:
+			uri = source.URL
+		case !strings.ContainsAny(arg, `/\.`):
+			return nil, fmt.Errorf("source %q is not defined in sources", arg)
+		default:
+			path, err := filepath.Abs(arg)
+			if err != nil {
+				return nil, err
+			}
+			uri = "file://" + path
+		}
+	}
+
+	data, err := config.LoadData(uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", arg, err)
+	}
+	return data, nil
+}
+
+// findDiffSource 查找参数对应的源, 参数为 name 或 name@version
+func findDiffSource(cfg *config.Config, arg string) (config.Source, string, bool) {
+	if cfg == nil {
+		return config.Source{}, "", false
+	}
+	if source, ok := findSource(cfg, arg); ok {
+		return source, "", true
+	}
+	if i := strings.LastIndex(arg, "@"); i > 0 {
+		if source, ok := findSource(cfg, arg[:i]); ok && i+1 < len(arg) {
+			return source, arg[i+1:], true
+		}
+	}
+	return config.Source{}, "", false
+}
+
+// loadSourceVersion 从 cache_dir 中的历史版本加载源的指定版本
+func loadSourceVersion(cfg *config.Config, source config.Source, version string) ([]byte, error) {
+	if cfg.CacheDir == "" {
+		return nil, fmt.Errorf("source %s@%s: versions are only kept on disk when cache_dir is set", source.Name, version)
+	}
+
+	sourceManager := mixer.NewSourceManager([]config.Source{source},
+		mixer.WithCacheDir(cfg.CacheDir),
+		mixer.WithHistorySize(cfg.HistorySize),
+	)
+	defer sourceManager.Close()
+
+	data, err := sourceManager.VersionData(source.Name, version)
+	if err != nil {
+		return nil, fmt.Errorf("source %s@%s: %w", source.Name, version, err)
+	}
+	return data, nil
+}
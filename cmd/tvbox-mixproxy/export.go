@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/wayjam/tvbox-mixproxy/config"
+	"github.com/wayjam/tvbox-mixproxy/pkg/export"
+)
+
+func newExportCmd(opts *rootOptions) *cobra.Command {
+	var exportOpts export.Options
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write the mixed configs, assets and spider jars into a directory for static hosting",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := opts.load(config.NewLoader(opts.cfgFile))
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			if exportOpts.BaseURL == "" {
+				exportOpts.BaseURL = cfg.ExternalURL
+			}
+			if exportOpts.BaseURL == "" {
+				return fmt.Errorf("--base-url or external_url is required")
+			}
+
+			sourceManager := newSourceManager(cfg)
+			defer sourceManager.Close()
+
+			result, err := export.Export(cfg, sourceManager, exportOpts)
+			if err != nil {
+				return err
+			}
+
+			for _, warning := range result.Warnings {
+				fmt.Fprintln(cmd.ErrOrStderr(), "warning:", warning)
+			}
+			for _, file := range result.Files {
+				fmt.Fprintln(cmd.OutOrStdout(), file)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&exportOpts.Dir, "dir", "public", "output directory")
+	cmd.Flags().StringVar(&exportOpts.BaseURL, "base-url", "", "URL the output directory is served at (default is external_url)")
+
+	return cmd
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/tidwall/gjson"
+	"github.com/wayjam/tvbox-mixproxy/config"
+	"github.com/wayjam/tvbox-mixproxy/pkg/mixer"
+)
+
+// inspectTable 描述 inspect 输出的一个表格: 数组字段及需要展示的列
+type inspectTable struct {
+	field   string
+	columns []string // 列对应的 gjson 路径, 空字符串表示元素本身
+}
+
+var inspectTables = []inspectTable{
+	{field: "sites", columns: []string{"key", "name", "type", "api", "searchable"}},
+	{field: "lives", columns: []string{"name", "type", "url"}},
+	{field: "parses", columns: []string{"name", "type", "url"}},
+	{field: "doh", columns: []string{"name", "url"}},
+	{field: "urls", columns: []string{"name", "url"}},
+}
+
+var repoFilterRegex = regexp.MustCompile(`^repos\[(\d+)\]$`)
+
+func newInspectCmd(opts *rootOptions) *cobra.Command {
+	var (
+		filter   string
+		filterBy string
+		include  string
+		exclude  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "inspect <source-name|url>",
+		Short: "Show the sites, lives, parses and doh a source contains",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var cfg *config.Config
+			uri := args[0]
+			if !strings.Contains(uri, "://") || filter != "" {
+				var err error
+				cfg, err = opts.load(config.NewLoader(opts.cfgFile))
+				if err != nil {
+					return fmt.Errorf("failed to load config: %w", err)
+				}
+			}
+			if !strings.Contains(uri, "://") {
+				source, ok := findSource(cfg, uri)
+				if !ok {
+					return fmt.Errorf("source %q is not defined in sources", uri)
+				}
+				uri = source.URL
+			}
+
+			data, err := config.LoadData(uri)
+			if err != nil {
+				return fmt.Errorf("failed to load %s: %w", uri, err)
+			}
+
+			out := cmd.OutOrStdout()
+			if filter == "" {
+				if spider := gjson.GetBytes(data, "spider"); spider.Exists() {
+					fmt.Fprintf(out, "spider: %s\n\n", spider.String())
+				}
+				for _, table := range inspectTables {
+					array := gjson.GetBytes(data, table.field)
+					if !array.IsArray() {
+						continue
+					}
+					printTable(out, table, array.Array(), "")
+				}
+				return nil
+			}
+
+			opt, err := filterOpt(cfg, filter)
+			if err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("filter-by") {
+				opt.FilterBy = filterBy
+			}
+			if cmd.Flags().Changed("include") {
+				opt.Include = include
+			}
+			if cmd.Flags().Changed("exclude") {
+				opt.Exclude = exclude
+			}
+
+			kept, dropped, err := mixer.PreviewFilter(data, opt)
+			if err != nil {
+				return fmt.Errorf("failed to filter %s: %w", opt.Field, err)
+			}
+
+			table := inspectTable{field: opt.Field, columns: []string{opt.FilterBy}}
+			for _, t := range inspectTables {
+				if t.field == opt.Field {
+					table = t
+				}
+			}
+			fmt.Fprintf(out, "filter_by: %q include: %q exclude: %q\n\n", opt.FilterBy, opt.Include, opt.Exclude)
+			printTable(out, table, kept, "KEEP")
+			printTable(out, table, dropped, "DROP")
+			fmt.Fprintf(out, "kept %d, dropped %d\n", len(kept), len(dropped))
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&filter, "filter", "", "preview the configured filter of a field, eg. sites, lives or repos[0]")
+	cmd.Flags().StringVar(&filterBy, "filter-by", "", "override filter_by of the previewed filter")
+	cmd.Flags().StringVar(&include, "include", "", "override include of the previewed filter")
+	cmd.Flags().StringVar(&exclude, "exclude", "", "override exclude of the previewed filter")
+
+	return cmd
+}
+
+func findSource(cfg *config.Config, name string) (config.Source, bool) {
+	for _, source := range cfg.Sources {
+		if source.Name == name {
+			return source, true
+		}
+	}
+	return config.Source{}, false
+}
+
+// filterOpt 返回配置中指定字段的过滤配置, 未配置过滤依据时使用该字段默认的 key
+func filterOpt(cfg *config.Config, field string) (config.ArrayMixOpt, error) {
+	var opt config.ArrayMixOpt
+
+	if m := repoFilterRegex.FindStringSubmatch(field); m != nil {
+		i, _ := strconv.Atoi(m[1])
+		if i >= len(cfg.MultiRepoOpt.Repos) {
+			return opt, fmt.Errorf("multi_repo_opt.repos has only %d item(s)", len(cfg.MultiRepoOpt.Repos))
+		}
+		opt = cfg.MultiRepoOpt.Repos[i]
+	} else {
+		opts := map[string]config.ArrayMixOpt{
+			"sites":  cfg.SingleRepoOpt.Sites,
+			"doh":    cfg.SingleRepoOpt.DOH,
+			"lives":  cfg.SingleRepoOpt.Lives,
+			"parses": cfg.SingleRepoOpt.Parses,
+			"flags":  cfg.SingleRepoOpt.Flags,
+			"rules":  cfg.SingleRepoOpt.Rules,
+			"ads":    cfg.SingleRepoOpt.Ads,
+		}
+		var ok bool
+		if opt, ok = opts[field]; !ok {
+			return opt, fmt.Errorf("unknown filter field %q", field)
+		}
+	}
+
+	// 与混合时使用的配置保持一致, 不补充默认值
+	return opt, nil
+}
+
+// printTable 以表格形式输出数组字段, status 非空时在首列输出
+func printTable(out io.Writer, table inspectTable, items []gjson.Result, status string) {
+	fmt.Fprintf(out, "%s (%d)\n", table.field, len(items))
+	if len(items) == 0 {
+		fmt.Fprintln(out)
+		return
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	header := make([]string, 0, len(table.columns)+1)
+	if status != "" {
+		header = append(header, "STATUS")
+	}
+	for _, column := range table.columns {
+		if column == "" {
+			column = "value"
+		}
+		header = append(header, strings.ToUpper(column))
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+
+	for _, item := range items {
+		row := make([]string, 0, len(header))
+		if status != "" {
+			row = append(row, status)
+		}
+		for _, column := range table.columns {
+			value := item
+			if column != "" {
+				value = item.Get(column)
+			}
+			row = append(row, value.String())
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+	fmt.Fprintln(out)
+}
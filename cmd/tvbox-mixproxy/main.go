@@ -1,37 +1,107 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
+	fiberlog "github.com/gofiber/fiber/v3/log"
 	"github.com/spf13/cobra"
 	"github.com/wayjam/tvbox-mixproxy/config"
 	"github.com/wayjam/tvbox-mixproxy/server"
 )
 
+// rootOptions 为所有子命令共享的全局参数
+type rootOptions struct {
+	cfgFile string
+	port    int
+}
+
+// load 使用 loader 读取配置, 并应用命令行参数的覆盖
+func (o *rootOptions) load(loader *config.Loader) (*config.Config, error) {
+	cfg, err := loader.Load()
+	if err != nil {
+		return nil, err
+	}
+	if o.port != 0 {
+		cfg.ServerPort = o.port
+	}
+	return cfg, nil
+}
+
 func main() {
-	var cfgFile string
-	var port int
+	opts := &rootOptions{}
 	rootCmd := &cobra.Command{
 		Use:   "tvbox-mixproxy",
 		Short: "TVBox MixProxy server",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			cfg, err := config.LoadServerConfig(cfgFile)
+			loader := config.NewLoader(opts.cfgFile)
+			cfg, err := opts.load(loader)
 			if err != nil {
 				return fmt.Errorf("failed to load config: %w", err)
 			}
 
-			if port != 0 {
-				cfg.ServerPort = port
+			svr := server.NewServer(cfg)
+
+			// 配置文件变化或收到 SIGHUP 时重新加载配置, 新配置无效时保留旧配置
+			reload := func() {
+				cfg, err := opts.load(loader)
+				if err != nil {
+					fiberlog.Errorf("failed to reload config, keeping the current one: %v", err)
+					return
+				}
+				svr.ReloadConfig(cfg)
+			}
+			if err := loader.Watch(reload); err != nil {
+				fiberlog.Warnf("config file changes will not be reloaded: %v", err)
 			}
 
-			svr := server.NewServer(cfg)
-			return svr.Run()
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+			go func() {
+				for range hup {
+					reload()
+				}
+			}()
+
+			runErr := make(chan error, 1)
+			go func() {
+				runErr <- svr.Run()
+			}()
+
+			// 收到 SIGINT/SIGTERM 时等待处理中的请求完成后再退出
+			quit := make(chan os.Signal, 1)
+			signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+			select {
+			case err := <-runErr:
+				return err
+			case sig := <-quit:
+				fiberlog.Infof("received %s, shutting down", sig)
+			}
+			signal.Stop(quit)
+
+			ctx, cancel := context.WithTimeout(context.Background(), svr.ShutdownTimeout())
+			defer cancel()
+			if err := svr.Shutdown(ctx); err != nil {
+				return fmt.Errorf("failed to shut down gracefully: %w", err)
+			}
+
+			return <-runErr
 		},
 	}
 
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.tvbox_mixproxy.yaml)")
-	rootCmd.PersistentFlags().IntVar(&port, "port", 8080, "server port (overrides config file if specified)")
+	rootCmd.PersistentFlags().StringVar(&opts.cfgFile, "config", "", "config file (default is $HOME/.tvbox_mixproxy.yaml)")
+	rootCmd.PersistentFlags().IntVar(&opts.port, "port", 8080, "server port (overrides config file if specified)")
+
+	rootCmd.AddCommand(newMixCmd(opts))
+	rootCmd.AddCommand(newValidateCmd(opts))
+	rootCmd.AddCommand(newInspectCmd(opts))
+	rootCmd.AddCommand(newDiffCmd(opts))
+	rootCmd.AddCommand(newExportCmd(opts))
+	rootCmd.AddCommand(newMirrorCmd(opts))
 
 	if err := rootCmd.Execute(); err != nil {
 		log.Fatal(err)
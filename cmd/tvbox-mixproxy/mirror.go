@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/wayjam/tvbox-mixproxy/config"
+	"github.com/wayjam/tvbox-mixproxy/pkg/mirror"
+)
+
+func newMirrorCmd(opts *rootOptions) *cobra.Command {
+	var mirrorOpts mirror.Options
+
+	cmd := &cobra.Command{
+		Use:   "mirror <source_name>",
+		Short: "Snapshot a single source with its spider jars and assets into mirror_dir",
+		Long: "Download the config of a single source, its spider jars and the relative or same-host assets\n" +
+			"referenced by sites, lives and parses into {mirror_dir}/{source_name}. URLs in the mirrored config\n" +
+			"point to {external_url}/mirror/, which is served only when mirror_dir is configured. Use the printed\n" +
+			"file:// url as the url of a source to serve it.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := opts.load(config.NewLoader(opts.cfgFile))
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			source, ok := findSource(cfg, args[0])
+			if !ok {
+				return fmt.Errorf("source %s is not defined in config", args[0])
+			}
+			if mirrorOpts.Dir == "" {
+				mirrorOpts.Dir = cfg.MirrorDir
+			}
+			if mirrorOpts.Dir == "" {
+				return fmt.Errorf("--dir or mirror_dir is required")
+			}
+			if mirrorOpts.BaseURL == "" {
+				mirrorOpts.BaseURL = cfg.ExternalURL
+			}
+			if mirrorOpts.BaseURL == "" {
+				return fmt.Errorf("--base-url or external_url is required")
+			}
+
+			result, err := mirror.Mirror(source, mirrorOpts)
+			if err != nil {
+				return err
+			}
+
+			for _, warning := range result.Warnings {
+				fmt.Fprintln(cmd.ErrOrStderr(), "warning:", warning)
+			}
+			for _, file := range result.Files {
+				fmt.Fprintln(cmd.OutOrStdout(), file)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "source %s mirrored to %s, url: %s\n", source.Name, result.Dir, result.URL)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&mirrorOpts.Dir, "dir", "", "mirror directory (default is mirror_dir)")
+	cmd.Flags().StringVar(&mirrorOpts.BaseURL, "base-url", "", "URL the proxy is served at (default is external_url)")
+
+	return cmd
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/wayjam/tvbox-mixproxy/config"
+	"github.com/wayjam/tvbox-mixproxy/pkg/mixer"
+)
+
+const (
+	profileSingle = "single"
+	profileMulti  = "multi"
+)
+
+func newMixCmd(opts *rootOptions) *cobra.Command {
+	var (
+		profile string
+		out     string
+		pretty  bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "mix",
+		Short: "Fetch sources once and write the mixed config without starting the server",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := opts.load(config.NewLoader(opts.cfgFile))
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+
+			sourceManager := newSourceManager(cfg)
+			defer sourceManager.Close()
+
+			result, report, err := mixProfile(cfg, sourceManager, profile)
+			if err != nil {
+				return err
+			}
+			for _, warning := range report.Warnings {
+				fmt.Fprintln(cmd.ErrOrStderr(), "warning:", warning)
+			}
+
+			var data []byte
+			if pretty {
+				data, err = json.MarshalIndent(result, "", "  ")
+			} else {
+				data, err = json.Marshal(result)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to encode %s repo: %w", profile, err)
+			}
+
+			return writeOutput(out, cmd.OutOrStdout(), data)
+		},
+	}
+
+	cmd.Flags().StringVar(&profile, "profile", profileSingle, "which repo to mix: single or multi")
+	cmd.Flags().StringVarP(&out, "out", "o", "-", "output file, - means stdout")
+	cmd.Flags().BoolVar(&pretty, "pretty", false, "indent the JSON output")
+
+	return cmd
+}
+
+// mixProfile 根据 profile 混合单仓或多仓配置
+func mixProfile(cfg *config.Config, sourcer mixer.Sourcer, profile string) (any, *mixer.MixReport, error) {
+	switch profile {
+	case profileSingle:
+		if cfg.SingleRepoOpt.Disable {
+			return nil, nil, fmt.Errorf("single repo is disabled")
+		}
+		result, report, err := mixer.MixRepoWithReport(cfg, sourcer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to mix single repo: %w", err)
+		}
+		return result, report, nil
+	case profileMulti:
+		if cfg.MultiRepoOpt.Disable {
+			return nil, nil, fmt.Errorf("multi repo is disabled")
+		}
+		result, report, err := mixer.MixMultiRepoWithReport(cfg, sourcer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to mix multi repo: %w", err)
+		}
+		return result, report, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown profile %q, should be %s or %s", profile, profileSingle, profileMulti)
+	}
+}
+
+// newSourceManager 按配置创建源管理器, 配置了 cache_dir 时使用其中的缓存与固定版本
+func newSourceManager(cfg *config.Config) *mixer.SourceManager {
+	return mixer.NewSourceManager(cfg.Sources,
+		mixer.WithCacheDir(cfg.CacheDir),
+		mixer.WithHistorySize(cfg.HistorySize),
+	)
+}
+
+// writeOutput 将数据写入文件, out 为 - 时写入 stdout
+func writeOutput(out string, stdout io.Writer, data []byte) error {
+	if out == "-" || out == "" {
+		_, err := stdout.Write(append(data, '\n'))
+		return err
+	}
+
+	if err := os.WriteFile(out, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", out, err)
+	}
+	return nil
+}
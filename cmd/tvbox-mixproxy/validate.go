@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"github.com/wayjam/tvbox-mixproxy/config"
+	"github.com/wayjam/tvbox-mixproxy/pkg/mixer"
+)
+
+func newValidateCmd(opts *rootOptions) *cobra.Command {
+	var checkSites bool
+
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check the config file for unknown keys, undefined sources, bad regexes and urls",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			loader := config.NewLoader(opts.cfgFile)
+			issues, err := loader.Lint()
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+
+			for _, issue := range issues {
+				fmt.Fprintln(cmd.OutOrStdout(), issue)
+			}
+			if len(issues) > 0 {
+				cmd.SilenceUsage = true
+				return fmt.Errorf("found %d issue(s) in config", len(issues))
+			}
+
+			if checkSites {
+				cfg, err := opts.load(loader)
+				if err != nil {
+					return fmt.Errorf("failed to load config: %w", err)
+				}
+				if n := validateSites(cmd.OutOrStdout(), cfg); n > 0 {
+					cmd.SilenceUsage = true
+					return fmt.Errorf("found %d site(s) whose spider class is missing", n)
+				}
+			}
+
+			if file := loader.ConfigFileUsed(); file != "" {
+				fmt.Fprintf(cmd.OutOrStdout(), "config %s is valid\n", file)
+			} else {
+				fmt.Fprintln(cmd.OutOrStdout(), "config is valid")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&checkSites, "sites", false, "fetch sources and spider jars, and check that the spider class of every csp site exists")
+
+	return cmd
+}
+
+// validateSites 检查每个单仓源以及混合结果中 csp 站点的 spider 类, 输出问题并返回问题数量
+func validateSites(out io.Writer, cfg *config.Config) int {
+	sourceManager := newSourceManager(cfg)
+	defer sourceManager.Close()
+
+	count := 0
+	for _, source := range cfg.Sources {
+		if source.Disabled || source.Type != config.SourceTypeSingle {
+			continue
+		}
+		if _, err := sourceManager.GetSource(source.Name); err != nil {
+			fmt.Fprintf(out, "source %s: %v\n", source.Name, err)
+			continue
+		}
+		issues, _ := sourceManager.CheckSites(source.Name, true)
+		for _, issue := range issues {
+			fmt.Fprintf(out, "source %s: %s\n", source.Name, issue)
+		}
+		count += len(issues)
+	}
+
+	// 混合后的站点可能与 spider 来自不同的源, 各源的 jar 已在上面下载, 混合时的检查使用缓存的 jar
+	if !cfg.SingleRepoOpt.Disable {
+		_, report, err := mixer.MixRepoWithReport(cfg, sourceManager)
+		if err != nil {
+			fmt.Fprintf(out, "repo: %v\n", err)
+		}
+		for _, issue := range report.SiteIssues {
+			fmt.Fprintf(out, "repo: %s\n", issue)
+		}
+		count += len(report.SiteIssues)
+	}
+
+	return count
+}
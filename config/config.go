@@ -2,7 +2,12 @@ package config
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 )
 
@@ -13,6 +18,17 @@ type Config struct {
 	Sources       []Source      `mapstructure:"sources"`         // 源配置
 	SingleRepoOpt SingleRepoOpt `mapstructure:"single_repo_opt"` // 单仓源配置
 	MultiRepoOpt  MultiRepoOpt  `mapstructure:"multi_repo_opt"`  // 多仓源配置
+	BestEffort    bool          `mapstructure:"best_effort"`     // 尽力混合, 单个字段失败时跳过该字段而不是整体失败
+	SiteCheck     SiteCheckOpt  `mapstructure:"site_check"`      // 检查 csp 站点的 spider 类是否存在的配置
+	URLRewrites   []URLRewrite  `mapstructure:"url_rewrites"`    // 混合结果中地址的改写规则, 按顺序依次应用
+	ResProxy      ResProxyOpt   `mapstructure:"res_proxy"`       // 资源代理配置, 启用后选定字段的地址经 /v1/res 代理并缓存
+	AdminToken    string        `mapstructure:"admin_token"`     // 管理接口令牌, 为空时禁用管理接口
+	CacheDir      string        `mapstructure:"cache_dir"`       // 缓存目录, 用于保存源的历史版本, 为空时仅保存在内存中
+	HistorySize   int           `mapstructure:"history_size"`    // 每个源保留的历史版本数量, 默认 5
+	MirrorDir     string        `mapstructure:"mirror_dir"`      // 镜像目录, 保存 mirror 命令生成的源镜像, 配置后通过 /mirror 访问
+	StaticDir     string        `mapstructure:"static_dir"`      // 静态文件目录, 通过 /static 访问, 为空时不启用
+	Prefetch      PrefetchOpt   `mapstructure:"prefetch"`        // 启动时预取源的配置
+	Shutdown      ShutdownOpt   `mapstructure:"shutdown"`        // 优雅退出的配置
 }
 
 func (c *Config) Fixture() {
@@ -46,9 +62,21 @@ func (c *Config) Fixture() {
 	}
 }
 
+// fillFallbackSourceName 未配置 source_name 的字段使用全局降级源,
+// 已配置的字段将全局降级源追加到降级链末尾
 func (c *Config) fillFallbackSourceName(opt *MixOpt) {
+	fallback := c.SingleRepoOpt.Fallback.SourceName
 	if opt.SourceName == "" {
-		opt.SourceName = c.SingleRepoOpt.Fallback.SourceName
+		opt.SourceName = fallback
+		return
+	}
+	for _, name := range opt.Fallbacks {
+		if name == fallback {
+			return
+		}
+	}
+	if opt.SourceName != fallback {
+		opt.Fallbacks = append(opt.Fallbacks, fallback)
 	}
 }
 
@@ -57,6 +85,42 @@ type LogOpt struct {
 	Level  int    `mapstructure:"level"`  // 日志级别, 0: Trace, 1: Debug, 2: Info, 3: Warn, 4: Error, 5: Fatal, 6: Panic
 }
 
+// PrefetchOpt 为启动时并发预取所有源的配置, 预取期间服务已开始监听
+type PrefetchOpt struct {
+	Concurrency int `mapstructure:"concurrency"` // 最大并发数, 默认 8
+	Timeout     int `mapstructure:"timeout"`     // 预取的总超时时间, 单位为秒, 默认 30
+}
+
+// URLRewrite 为地址改写规则, 地址中匹配 Pattern 的部分替换为 Replacement, 可使用 $1 等引用分组
+type URLRewrite struct {
+	Pattern     string `mapstructure:"pattern"`     // 正则表达式
+	Replacement string `mapstructure:"replacement"` // 替换内容
+}
+
+// SiteCheckOpt 为混合时检查 csp 站点的 spider 类是否存在于 jar 中的配置
+type SiteCheckOpt struct {
+	DropMissing bool `mapstructure:"drop_missing"` // 丢弃找不到 spider 类的站点, 默认仅告警
+}
+
+// ResProxyFields 为支持经资源代理访问的字段
+var ResProxyFields = []string{"wallpaper", "logo", "sites.ext", "lives.url"}
+
+// ResProxyOpt 为 /v1/res 资源代理的配置, 代理地址带有签名, 只能访问混合结果中引用的资源
+type ResProxyOpt struct {
+	Enable      bool              `mapstructure:"enable"`        // 是否启用
+	Secret      string            `mapstructure:"secret"`        // 签名密钥, 启用时必填
+	Fields      []string          `mapstructure:"fields"`        // 经代理访问的字段, 取值见 ResProxyFields
+	TTL         int               `mapstructure:"ttl"`           // 缓存有效期, 单位为秒, 默认 600; 过期后重新校验, 上游失败时继续使用缓存
+	MaxSize     int               `mapstructure:"max_size"`      // 缓存总大小上限, 单位为 MB, 默认 256, 超出时淘汰最久未使用的资源
+	MaxFileSize int               `mapstructure:"max_file_size"` // 单个资源大小上限, 单位为 MB, 默认 16, 超出时重定向到上游
+	Headers     map[string]string `mapstructure:"headers"`       // 请求上游时附加的请求头, eg. User-Agent
+}
+
+// ShutdownOpt 为收到 SIGINT/SIGTERM 后优雅退出的配置
+type ShutdownOpt struct {
+	Timeout int `mapstructure:"timeout"` // 等待处理中的请求完成与缓存写入的时间, 单位为秒, 默认 15
+}
+
 type SingleRepoOpt struct {
 	Disable   bool        `mapstructure:"disable"` // 是否禁用单仓源
 	Spider    MixOpt      `mapstructure:"spider"`
@@ -79,9 +143,24 @@ type MultiRepoOpt struct {
 }
 
 type MixOpt struct {
-	SourceName string `mapstructure:"source_name"`
-	Field      string `mapstructure:"field"`    // 内部使用，无需配置
-	Disabled   bool   `mapstructure:"disabled"` // 是否禁用该字段
+	SourceName string   `mapstructure:"source_name"`
+	Fallbacks  []string `mapstructure:"fallbacks"` // 降级源, 主源失败、缺少该字段或过滤后为空时依次尝试
+	Field      string   `mapstructure:"field"`     // 内部使用，无需配置
+	Disabled   bool     `mapstructure:"disabled"`  // 是否禁用该字段
+}
+
+// SourceNames 返回按顺序尝试的源名称: 主源在前, 降级源依次在后
+func (o MixOpt) SourceNames() []string {
+	names := make([]string, 0, 1+len(o.Fallbacks))
+	seen := make(map[string]bool, 1+len(o.Fallbacks))
+	for _, name := range append([]string{o.SourceName}, o.Fallbacks...) {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
 }
 
 type ArrayMixOpt struct {
@@ -95,7 +174,9 @@ type Source struct {
 	Name     string     `mapstructure:"name"`     // 源名称, 唯一标识， 用来标识用在配置中
 	URL      string     `mapstructure:"url"`      // 源地址
 	Type     SourceType `mapstructure:"type"`     // 源类型
-	Interval int        `mapstructure:"interval"` // 源更新频率，单位为秒
+	Interval int        `mapstructure:"interval"` // 源更新频率，单位为秒, 为 0 时使用上游的 Cache-Control: max-age 或默认值
+	Cron     string     `mapstructure:"cron"`     // 按 cron 表达式更新, 与 interval 互斥
+	Disabled bool       `mapstructure:"disabled"` // 是否禁用该源, 可通过管理接口在运行时切换
 }
 
 type SourceType string
@@ -106,6 +187,16 @@ const (
 )
 
 func LoadServerConfig(cfgFile string) (*Config, error) {
+	return NewLoader(cfgFile).Load()
+}
+
+// Loader 负责读取配置文件, 并支持在配置文件变化时重新加载
+type Loader struct {
+	v  *viper.Viper
+	mu sync.Mutex
+}
+
+func NewLoader(cfgFile string) *Loader {
 	v := viper.New()
 
 	if cfgFile != "" {
@@ -121,20 +212,122 @@ func LoadServerConfig(cfgFile string) (*Config, error) {
 	v.SetEnvPrefix("TVBOX_MIXPROXY")
 	v.AutomaticEnv() // read in environment variables that match
 
+	return &Loader{v: v}
+}
+
+// Load 读取并校验配置, 每次调用都会重新读取配置文件
+func (l *Loader) Load() (*Config, error) {
+	cfg, issues, err := l.read()
+	if err != nil {
+		return nil, err
+	}
+	if len(issues) > 0 {
+		return nil, fmt.Errorf("invalid config:\n%w", IssuesError(issues))
+	}
+
+	cfg.Fixture()
+
+	return cfg, nil
+}
+
+// Lint 读取配置并返回其中的所有问题, 仅在配置无法读取或解析时返回错误
+func (l *Loader) Lint() ([]Issue, error) {
+	_, issues, err := l.read()
+	return issues, err
+}
+
+func (l *Loader) read() (*Config, []Issue, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	v := l.v
+
 	// If a config file is found, read it in
 	if err := v.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", v.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", v.ConfigFileUsed())
 	} else if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
 		// Config file was found but another error was produced
-		return nil, fmt.Errorf("error reading config file: %v", err)
+		return nil, nil, fmt.Errorf("error reading config file: %v", err)
 	}
 
 	var cfg Config
 	if err := v.Unmarshal(&cfg); err != nil {
-		return nil, fmt.Errorf("unable to decode into struct: %v", err)
+		return nil, nil, fmt.Errorf("unable to decode into struct: %v", err)
 	}
 
-	cfg.Fixture()
+	issues, err := lint(v.ConfigFileUsed(), &cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &cfg, issues, nil
+}
+
+// ConfigFileUsed 返回当前使用的配置文件路径, 未找到配置文件时为空
+func (l *Loader) ConfigFileUsed() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.v.ConfigFileUsed()
+}
+
+// watchDebounce 为配置文件变化后等待的时间, 编辑器保存时的多次写入只触发一次重新加载
+const watchDebounce = 200 * time.Millisecond
+
+// Watch 监听配置文件变化, 变化时调用 onChange; 未找到配置文件时不做任何事.
+// 短时间内的多次变化只调用一次, 且不会并发调用. onChange 应通过 Load 重新读取配置,
+// 与 SIGHUP 等其他途径的重新加载一样在 Loader 的锁内读取
+func (l *Loader) Watch(onChange func()) error {
+	file := l.ConfigFileUsed()
+	if file == "" {
+		return nil
+	}
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+
+	// 监听所在目录, 编辑器以重命名方式保存时同样能收到事件
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("watching config file: %w", err)
+	}
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("watching config file: %w", err)
+	}
+
+	// 上一次重新加载未完成时, 新触发的重新加载等待其结束
+	var reloading sync.Mutex
+	reload := func() {
+		reloading.Lock()
+		defer reloading.Unlock()
+		onChange()
+	}
 
-	return &cfg, nil
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != file || event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				if timer == nil {
+					timer = time.AfterFunc(watchDebounce, reload)
+				} else {
+					timer.Reset(watchDebounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				fmt.Fprintln(os.Stderr, "watching config file:", err)
+			}
+		}
+	}()
+	return nil
 }
@@ -1 +1,189 @@
 package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoaderReload(t *testing.T) {
+	cfgFile := filepath.Join(t.TempDir(), "tvbox_mixproxy.yaml")
+	err := os.WriteFile(cfgFile, []byte(`
+server_port: 8081
+sources:
+  - name: main
+    url: https://example.com/main.json
+    type: single
+`), 0644)
+	assert.NoError(t, err)
+
+	loader := NewLoader(cfgFile)
+	cfg, err := loader.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 8081, cfg.ServerPort)
+	assert.Len(t, cfg.Sources, 1)
+	assert.Equal(t, cfgFile, loader.ConfigFileUsed())
+
+	// 重新加载时读取最新的配置文件
+	err = os.WriteFile(cfgFile, []byte(`
+server_port: 8082
+sources:
+  - name: main
+    url: https://example.com/main.json
+    type: single
+  - name: extra
+    url: https://example.com/extra.json
+    type: multi
+`), 0644)
+	assert.NoError(t, err)
+
+	cfg, err = loader.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 8082, cfg.ServerPort)
+	assert.Len(t, cfg.Sources, 2)
+
+	// 无效配置返回错误
+	err = os.WriteFile(cfgFile, []byte(`
+sources:
+  - name: main
+    url: https://example.com/main.json
+  - name: main
+    url: https://example.com/other.json
+`), 0644)
+	assert.NoError(t, err)
+
+	_, err = loader.Load()
+	assert.ErrorContains(t, err, "duplicate source name")
+}
+
+func TestLoaderWatch(t *testing.T) {
+	cfgFile := filepath.Join(t.TempDir(), "tvbox_mixproxy.yaml")
+	write := func(port int) {
+		err := os.WriteFile(cfgFile, []byte(fmt.Sprintf("server_port: %d\nsources:\n  - name: main\n    url: https://example.com/main.json\n", port)), 0644)
+		assert.NoError(t, err)
+	}
+	write(8081)
+
+	loader := NewLoader(cfgFile)
+	_, err := loader.Load()
+	assert.NoError(t, err)
+
+	var changes atomic.Int64
+	var port atomic.Int64
+	err = loader.Watch(func() {
+		changes.Add(1)
+		if cfg, err := loader.Load(); err == nil {
+			port.Store(int64(cfg.ServerPort))
+		}
+	})
+	assert.NoError(t, err)
+
+	// 短时间内的多次写入只重新加载一次, 读取的是最后写入的配置
+	for p := 8082; p <= 8085; p++ {
+		write(p)
+	}
+	assert.Eventually(t, func() bool { return port.Load() == 8085 }, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(2 * watchDebounce)
+	assert.Equal(t, int64(1), changes.Load())
+
+	// 未找到配置文件时不监听
+	assert.NoError(t, NewLoader("").Watch(func() {}))
+}
+
+func TestLoaderWatchSerialized(t *testing.T) {
+	cfgFile := filepath.Join(t.TempDir(), "tvbox_mixproxy.yaml")
+	write := func() {
+		assert.NoError(t, os.WriteFile(cfgFile, []byte("sources:\n  - name: main\n    url: https://example.com/main.json\n"), 0644))
+	}
+	write()
+
+	loader := NewLoader(cfgFile)
+	_, err := loader.Load()
+	assert.NoError(t, err)
+
+	// 重新加载耗时超过防抖时间时, 后续的重新加载不会与之并发执行
+	var running, overlapped, changes atomic.Int64
+	err = loader.Watch(func() {
+		if running.Add(1) > 1 {
+			overlapped.Add(1)
+		}
+		time.Sleep(3 * watchDebounce)
+		running.Add(-1)
+		changes.Add(1)
+	})
+	assert.NoError(t, err)
+
+	write()
+	assert.Eventually(t, func() bool { return running.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+	write()
+	assert.Eventually(t, func() bool { return changes.Load() == 2 }, 5*time.Second, 10*time.Millisecond)
+	assert.Zero(t, overlapped.Load())
+}
+
+func TestConfigCheck(t *testing.T) {
+	validate := func(cfg *Config) error {
+		if issues := cfg.check(); len(issues) > 0 {
+			return IssuesError(issues)
+		}
+		return nil
+	}
+
+	cfg := &Config{Sources: []Source{
+		{Name: "a", URL: "https://example.com/a.json", Type: SourceTypeSingle},
+		{Name: "b", URL: "file:///app/b.json"},
+	}}
+	assert.NoError(t, validate(cfg))
+
+	cfg = &Config{Sources: []Source{{Name: "", URL: "https://example.com/a.json"}}}
+	assert.ErrorContains(t, validate(cfg), "name is required")
+
+	cfg = &Config{Sources: []Source{{Name: "a", URL: "https://example.com/a.json", Type: "unknown"}}}
+	assert.ErrorContains(t, validate(cfg), "unknown source type")
+
+	cfg = &Config{Sources: []Source{{Name: "a", URL: "https://example.com/a.json", Cron: "0 */6 * * *"}}}
+	assert.NoError(t, validate(cfg))
+
+	cfg = &Config{Sources: []Source{{Name: "a", URL: "https://example.com/a.json", Cron: "0 25 * * *"}}}
+	assert.ErrorContains(t, validate(cfg), "invalid hour field")
+
+	cfg = &Config{Sources: []Source{{Name: "a", URL: "https://example.com/a.json", Cron: "@daily", Interval: 60}}}
+	assert.ErrorContains(t, validate(cfg), "should not be set at the same time")
+
+	cfg = &Config{URLRewrites: []URLRewrite{{Pattern: `^https://raw\.githubusercontent\.com/`, Replacement: "https://mirror.example.com/"}}}
+	assert.NoError(t, validate(cfg))
+
+	cfg = &Config{URLRewrites: []URLRewrite{{Pattern: "(", Replacement: "x"}}}
+	assert.ErrorContains(t, validate(cfg), "url_rewrites[0].pattern")
+
+	cfg = &Config{ResProxy: ResProxyOpt{Enable: true, Secret: "s", Fields: []string{"sites.ext", "lives.url"}}}
+	assert.NoError(t, validate(cfg))
+
+	cfg = &Config{ResProxy: ResProxyOpt{Enable: true}}
+	assert.ErrorContains(t, validate(cfg), "res_proxy.secret")
+
+	cfg = &Config{ResProxy: ResProxyOpt{Fields: []string{"sites.api"}}}
+	assert.ErrorContains(t, validate(cfg), "res_proxy.fields[0]")
+}
+
+func TestFixtureFallback(t *testing.T) {
+	cfg := &Config{
+		SingleRepoOpt: SingleRepoOpt{
+			Spider:   MixOpt{SourceName: "main"},
+			Sites:    ArrayMixOpt{MixOpt: MixOpt{SourceName: "main", Fallbacks: []string{"backup"}}},
+			Lives:    ArrayMixOpt{MixOpt: MixOpt{SourceName: "backup"}},
+			Fallback: MixOpt{SourceName: "backup"},
+		},
+	}
+
+	cfg.Fixture()
+
+	assert.Equal(t, []string{"main", "backup"}, cfg.SingleRepoOpt.Spider.SourceNames())
+	assert.Equal(t, []string{"main", "backup"}, cfg.SingleRepoOpt.Sites.SourceNames())
+	assert.Equal(t, []string{"backup"}, cfg.SingleRepoOpt.Lives.SourceNames())
+	assert.Equal(t, []string{"backup"}, cfg.SingleRepoOpt.Logo.SourceNames())
+}
@@ -0,0 +1,327 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/wayjam/tvbox-mixproxy/pkg/cron"
+)
+
+// Issue 描述配置中的一个问题
+type Issue struct {
+	File    string // 配置文件路径, 无配置文件时为空
+	Line    int    // 问题所在行, 0 表示无法定位
+	Path    string // 配置项路径, eg. sources[1].name
+	Message string
+}
+
+func (i Issue) String() string {
+	var b strings.Builder
+	if i.File != "" {
+		b.WriteString(i.File)
+		if i.Line > 0 {
+			fmt.Fprintf(&b, ":%d", i.Line)
+		}
+		b.WriteString(": ")
+	}
+	if i.Path != "" {
+		b.WriteString(i.Path)
+		b.WriteString(": ")
+	}
+	b.WriteString(i.Message)
+	return b.String()
+}
+
+// IssuesError 将多个问题合并为一个错误
+type IssuesError []Issue
+
+func (e IssuesError) Error() string {
+	lines := make([]string, 0, len(e))
+	for _, issue := range e {
+		lines = append(lines, issue.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// lint 检查配置文件中的未知字段以及配置的合法性, 并为问题标注文件与行号
+func lint(file string, cfg *Config) ([]Issue, error) {
+	var (
+		issues []Issue
+		lines  = make(map[string]int)
+	)
+
+	ext := strings.ToLower(filepath.Ext(file))
+	if file != "" && (ext == ".yaml" || ext == ".yml") {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading config file: %v", err)
+		}
+
+		var root yaml.Node
+		if err := yaml.Unmarshal(content, &root); err != nil {
+			return nil, fmt.Errorf("error parsing config file: %v", err)
+		}
+		if len(root.Content) > 0 {
+			issues = walkYAML(root.Content[0], reflect.TypeOf(Config{}), "", lines)
+		}
+	}
+
+	issues = append(issues, cfg.check()...)
+	for i := range issues {
+		issues[i].File = file
+		if issues[i].Line == 0 {
+			issues[i].Line = lookupLine(lines, issues[i].Path)
+		}
+	}
+
+	return issues, nil
+}
+
+// walkYAML 按结构体的 mapstructure 标签遍历 YAML 节点, 记录每个配置项的行号并报告未知字段
+func walkYAML(node *yaml.Node, typ reflect.Type, path string, lines map[string]int) []Issue {
+	var issues []Issue
+
+	switch typ.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		fields := structFields(typ)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keyPath := joinPath(path, key.Value)
+			lines[keyPath] = key.Line
+
+			field, ok := fields[strings.ToLower(key.Value)]
+			if !ok {
+				issues = append(issues, Issue{Line: key.Line, Path: keyPath, Message: "unknown key"})
+				continue
+			}
+			issues = append(issues, walkYAML(value, field, keyPath, lines)...)
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return nil
+		}
+		for i, item := range node.Content {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			lines[itemPath] = item.Line
+			issues = append(issues, walkYAML(item, typ.Elem(), itemPath, lines)...)
+		}
+	}
+
+	return issues
+}
+
+// structFields 返回结构体的 mapstructure 键名到字段类型的映射, 展开 squash 的嵌入字段
+func structFields(typ reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("mapstructure")
+		name, opts, _ := strings.Cut(tag, ",")
+		if opts == "squash" {
+			for k, v := range structFields(field.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[strings.ToLower(name)] = field.Type
+	}
+	return fields
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// lookupLine 查找配置项的行号, 找不到时逐级向上查找父配置项
+func lookupLine(lines map[string]int, path string) int {
+	for path != "" {
+		if line, ok := lines[path]; ok {
+			return line
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return 0
+}
+
+// check 检查配置的语义合法性
+func (c *Config) check() []Issue {
+	var issues []Issue
+	report := func(path, format string, args ...any) {
+		issues = append(issues, Issue{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	sources := make(map[string]Source, len(c.Sources))
+	for i, source := range c.Sources {
+		path := fmt.Sprintf("sources[%d]", i)
+		if source.Name == "" {
+			report(path+".name", "name is required")
+		} else if _, ok := sources[source.Name]; ok {
+			report(path+".name", "duplicate source name %q", source.Name)
+		} else {
+			sources[source.Name] = source
+		}
+
+		if source.Type != "" && source.Type != SourceTypeSingle && source.Type != SourceTypeMulti {
+			report(path+".type", "unknown source type %q, should be %s or %s", source.Type, SourceTypeSingle, SourceTypeMulti)
+		}
+
+		if err := checkSourceURL(source.URL); err != nil {
+			report(path+".url", "%v", err)
+		}
+
+		if source.Interval < 0 {
+			report(path+".interval", "interval should not be negative")
+		}
+
+		if source.Cron != "" {
+			if _, err := cron.Parse(source.Cron); err != nil {
+				report(path+".cron", "%v", err)
+			} else if source.Interval > 0 {
+				report(path+".cron", "cron and interval should not be set at the same time")
+			}
+		}
+	}
+
+	if c.HistorySize < 0 {
+		report("history_size", "history_size should not be negative")
+	}
+	if c.Prefetch.Concurrency < 0 {
+		report("prefetch.concurrency", "concurrency should not be negative")
+	}
+	if c.Prefetch.Timeout < 0 {
+		report("prefetch.timeout", "timeout should not be negative")
+	}
+	if c.Shutdown.Timeout < 0 {
+		report("shutdown.timeout", "timeout should not be negative")
+	}
+
+	for i, rewrite := range c.URLRewrites {
+		path := fmt.Sprintf("url_rewrites[%d].pattern", i)
+		if rewrite.Pattern == "" {
+			report(path, "pattern is required")
+		} else if _, err := regexp.Compile(rewrite.Pattern); err != nil {
+			report(path, "invalid pattern: %v", err)
+		}
+	}
+
+	if c.ResProxy.Enable && c.ResProxy.Secret == "" {
+		report("res_proxy.secret", "secret is required when res_proxy is enabled")
+	}
+	for i, field := range c.ResProxy.Fields {
+		if !slices.Contains(ResProxyFields, field) {
+			report(fmt.Sprintf("res_proxy.fields[%d]", i), "unknown field %q, should be one of %s", field, strings.Join(ResProxyFields, ", "))
+		}
+	}
+	if c.ResProxy.TTL < 0 || c.ResProxy.MaxSize < 0 || c.ResProxy.MaxFileSize < 0 {
+		report("res_proxy", "ttl, max_size and max_file_size should not be negative")
+	}
+
+	// 检查字段引用的源是否存在且类型正确
+	checkMixOpt := func(path string, opt MixOpt, want SourceType, allowFile bool) {
+		if opt.Disabled {
+			return
+		}
+		refs := append([]string{opt.SourceName}, opt.Fallbacks...)
+		for i, name := range refs {
+			refPath := path + ".source_name"
+			if i > 0 {
+				refPath = fmt.Sprintf("%s.fallbacks[%d]", path, i-1)
+			}
+			if name == "" {
+				continue
+			}
+			if allowFile && strings.HasPrefix(name, "file://") {
+				continue
+			}
+			source, ok := sources[name]
+			if !ok {
+				report(refPath, "source %q is not defined in sources", name)
+				continue
+			}
+			if source.Type != "" && source.Type != want {
+				report(refPath, "source %q is a %s source, should be a %s source", name, source.Type, want)
+			}
+		}
+	}
+	checkArrayMixOpt := func(path string, opt ArrayMixOpt, want SourceType) {
+		checkMixOpt(path, opt.MixOpt, want, false)
+		if _, err := regexp.Compile(opt.Include); err != nil {
+			report(path+".include", "invalid include regex: %v", err)
+		}
+		if _, err := regexp.Compile(opt.Exclude); err != nil {
+			report(path+".exclude", "invalid exclude regex: %v", err)
+		}
+	}
+
+	if !c.SingleRepoOpt.Disable {
+		opt := c.SingleRepoOpt
+		checkMixOpt("single_repo_opt.spider", opt.Spider, SourceTypeSingle, true)
+		checkMixOpt("single_repo_opt.wallpaper", opt.Wallpaper, SourceTypeSingle, false)
+		checkMixOpt("single_repo_opt.logo", opt.Logo, SourceTypeSingle, false)
+		checkArrayMixOpt("single_repo_opt.sites", opt.Sites, SourceTypeSingle)
+		checkArrayMixOpt("single_repo_opt.doh", opt.DOH, SourceTypeSingle)
+		checkArrayMixOpt("single_repo_opt.lives", opt.Lives, SourceTypeSingle)
+		checkArrayMixOpt("single_repo_opt.parses", opt.Parses, SourceTypeSingle)
+		checkArrayMixOpt("single_repo_opt.flags", opt.Flags, SourceTypeSingle)
+		checkArrayMixOpt("single_repo_opt.rules", opt.Rules, SourceTypeSingle)
+		checkArrayMixOpt("single_repo_opt.ads", opt.Ads, SourceTypeSingle)
+		checkMixOpt("single_repo_opt.fallback", opt.Fallback, SourceTypeSingle, false)
+	}
+
+	if !c.MultiRepoOpt.Disable {
+		for i, repo := range c.MultiRepoOpt.Repos {
+			checkArrayMixOpt(fmt.Sprintf("multi_repo_opt.repos[%d]", i), repo, SourceTypeMulti)
+		}
+	}
+
+	return issues
+}
+
+// checkSourceURL 检查源地址是否为支持的协议
+func checkSourceURL(uri string) error {
+	if uri == "" {
+		return errors.New("url is required")
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("invalid url: %v", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		if u.Host == "" {
+			return fmt.Errorf("invalid url %q: missing host", uri)
+		}
+	case "file":
+		if u.Path == "" && u.Opaque == "" {
+			return fmt.Errorf("invalid url %q: missing file path", uri)
+		}
+	default:
+		return fmt.Errorf("unsupported url scheme %q, should be http, https or file", u.Scheme)
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoaderLint(t *testing.T) {
+	cfgFile := filepath.Join(t.TempDir(), "tvbox_mixproxy.yaml")
+	err := os.WriteFile(cfgFile, []byte(`server_port: 8080
+unknown_top: true
+sources:
+  - name: main
+    url: https://example.com/main.json
+    type: single
+  - name: main
+    url: ftp://example.com/dup.json
+    type: single
+  - name: multi
+    url: file:///app/multi.json
+    type: multi
+    intervall: 60
+single_repo_opt:
+  spider:
+    source_name: file:///app/spider.jar
+  sites:
+    source_name: multi
+    include: "("
+  lives:
+    source_name: missing
+    fallbacks: ["main", "gone"]
+multi_repo_opt:
+  repos:
+    - source_name: main
+      exclude: "[z-a]"
+`), 0644)
+	assert.NoError(t, err)
+
+	loader := NewLoader(cfgFile)
+	issues, err := loader.Lint()
+	assert.NoError(t, err)
+
+	var got []string
+	for _, issue := range issues {
+		got = append(got, issue.String())
+	}
+	assert.ElementsMatch(t, []string{
+		cfgFile + ":2: unknown_top: unknown key",
+		cfgFile + ":13: sources[2].intervall: unknown key",
+		cfgFile + `:7: sources[1].name: duplicate source name "main"`,
+		cfgFile + `:8: sources[1].url: unsupported url scheme "ftp", should be http, https or file`,
+		cfgFile + `:18: single_repo_opt.sites.source_name: source "multi" is a multi source, should be a single source`,
+		cfgFile + ":19: single_repo_opt.sites.include: invalid include regex: error parsing regexp: missing closing ): `(`",
+		cfgFile + `:21: single_repo_opt.lives.source_name: source "missing" is not defined in sources`,
+		cfgFile + `:22: single_repo_opt.lives.fallbacks[1]: source "gone" is not defined in sources`,
+		cfgFile + `:25: multi_repo_opt.repos[0].source_name: source "main" is a single source, should be a multi source`,
+		cfgFile + ":26: multi_repo_opt.repos[0].exclude: invalid exclude regex: error parsing regexp: invalid character class range: `z-a`",
+	}, got)
+
+	// 存在问题的配置无法加载
+	_, err = loader.Load()
+	assert.ErrorContains(t, err, "invalid config")
+}
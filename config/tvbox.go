@@ -9,6 +9,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // FlexInt 是一个灵活的整数类型，可以从 JSON 中的数字或字符串解析
@@ -110,36 +111,95 @@ type Rule struct {
 }
 
 func LoadData(uri string) ([]byte, error) {
-	var data []byte
-	var err error
+	data, _, err := LoadDataWithMeta(uri)
+	return data, err
+}
+
+// LoadDataWithMeta 与 LoadData 相同, 同时返回上游响应的元信息
+func LoadDataWithMeta(uri string) ([]byte, DataMeta, error) {
+	data, meta, err := FetchDataWithMeta(uri)
+	if err != nil {
+		return nil, meta, err
+	}
+
+	// Remove comments from JSON
+	re := regexp.MustCompile(`(?m)^\s*//.*$|/\*[\s\S]*?\*/`)
+	data = re.ReplaceAll(data, []byte{})
+
+	return data, meta, nil
+}
+
+// DataMeta 为读取数据时上游响应的元信息
+type DataMeta struct {
+	URL    string        // 数据的最终地址, 网络地址经过重定向时为重定向后的地址
+	MaxAge time.Duration // Cache-Control 中的 max-age, 未指定或禁止缓存时为 0, 本地文件始终为 0
+}
+
+// fetchTimeout 为请求上游的超时时间, 包括读取响应内容, 避免上游无响应时请求一直阻塞
+const fetchTimeout = 60 * time.Second
+
+var httpClient = &http.Client{Timeout: fetchTimeout}
+
+// FetchData 读取 uri 对应的原始数据, 不做任何处理, 适用于 jar 等二进制文件
+func FetchData(uri string) ([]byte, error) {
+	data, _, err := FetchDataWithMeta(uri)
+	return data, err
+}
+
+// FetchDataWithMeta 与 FetchData 相同, 同时返回上游响应的元信息
+func FetchDataWithMeta(uri string) ([]byte, DataMeta, error) {
+	var (
+		data []byte
+		meta DataMeta
+		err  error
+	)
 
 	if strings.HasPrefix(uri, "file://") {
 		// Load from local file
 		data, err = os.ReadFile(strings.TrimPrefix(uri, "file://"))
+		meta.URL = uri
 	} else if strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://") {
 		// Load from network URL
-		resp, err := http.Get(uri)
+		resp, err := httpClient.Get(uri)
 		if err != nil {
-			return nil, fmt.Errorf("failed to fetch data from URL: %v", err)
+			return nil, meta, fmt.Errorf("failed to fetch data from URL: %v", err)
 		}
 		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return nil, meta, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		}
 		data, err = io.ReadAll(resp.Body)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read data: %v", err)
+			return nil, meta, fmt.Errorf("failed to read data: %v", err)
 		}
+		meta.URL = resp.Request.URL.String()
+		meta.MaxAge = parseMaxAge(resp.Header.Get("Cache-Control"))
 	} else {
-		return nil, fmt.Errorf("unsupported URI scheme: %s", uri)
+		return nil, meta, fmt.Errorf("unsupported URI scheme: %s", uri)
 	}
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to read data: %v", err)
+		return nil, meta, fmt.Errorf("failed to read data: %v", err)
 	}
 
-	// Remove comments from JSON
-	re := regexp.MustCompile(`(?m)^\s*//.*$|/\*[\s\S]*?\*/`)
-	data = re.ReplaceAll(data, []byte{})
-
-	return data, nil
+	return data, meta, nil
+}
+
+// parseMaxAge 解析 Cache-Control 中的 max-age, no-store 与 no-cache 视为未指定
+func parseMaxAge(cacheControl string) time.Duration {
+	var maxAge time.Duration
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache":
+			return 0
+		case "max-age":
+			if seconds, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil && seconds > 0 {
+				maxAge = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return maxAge
 }
 
 func ParseMultiRepoConfig(data []byte) (*MultiRepoConfig, error) {
@@ -0,0 +1,233 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+type ChangeKind string
+
+const (
+	Added   ChangeKind = "added"
+	Removed ChangeKind = "removed"
+	Changed ChangeKind = "changed"
+)
+
+// ValueChange 描述单个字符串字段的变化
+type ValueChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// ItemChange 描述数组字段中按 key 匹配的一项的变化
+type ItemChange struct {
+	Kind   ChangeKind `json:"kind"`
+	Key    string     `json:"key"`
+	Name   string     `json:"name,omitempty"`   // 便于阅读的名称, 与 key 相同时为空
+	Fields []string   `json:"fields,omitempty"` // 发生变化的字段, 仅 Changed 时有值
+}
+
+// Result 为两个配置之间的语义差异
+type Result struct {
+	Values   map[string]ValueChange  `json:"values,omitempty"`   // spider/wallpaper/logo 等字符串字段
+	Sections map[string][]ItemChange `json:"sections,omitempty"` // sites/lives/parses 等数组字段
+}
+
+// Empty 返回两个配置是否没有差异
+func (r *Result) Empty() bool {
+	return len(r.Values) == 0 && len(r.Sections) == 0
+}
+
+// String 以便于阅读的文本形式输出差异
+func (r *Result) String() string {
+	if r.Empty() {
+		return "no changes\n"
+	}
+
+	var b strings.Builder
+	for _, field := range sortedKeys(r.Values) {
+		change := r.Values[field]
+		fmt.Fprintf(&b, "%s:\n  - %s\n  + %s\n", field, change.Old, change.New)
+	}
+	for _, section := range sortedKeys(r.Sections) {
+		fmt.Fprintf(&b, "%s:\n", section)
+		for _, change := range r.Sections[section] {
+			label := change.Key
+			if change.Name != "" {
+				label = fmt.Sprintf("%s (%s)", change.Key, change.Name)
+			}
+			switch change.Kind {
+			case Added:
+				fmt.Fprintf(&b, "  + %s\n", label)
+			case Removed:
+				fmt.Fprintf(&b, "  - %s\n", label)
+			case Changed:
+				fmt.Fprintf(&b, "  ~ %s: %s\n", label, strings.Join(change.Fields, ", "))
+			}
+		}
+	}
+	return b.String()
+}
+
+// section 描述一个按 key 比较的数组字段
+type section struct {
+	field string
+	key   string // 匹配同一项所用的 key, 为空表示元素本身 (字符串数组)
+	name  string // 便于阅读的名称字段
+}
+
+var (
+	repoValues   = []string{"spider", "wallpaper", "logo"}
+	repoSections = []section{
+		{field: "sites", key: "key", name: "name"},
+		{field: "lives", key: "name"},
+		{field: "parses", key: "name"},
+		{field: "doh", key: "name"},
+		{field: "rules", key: "name"},
+		{field: "flags"},
+		{field: "ads"},
+	}
+	multiRepoSections = []section{
+		{field: "urls", key: "name"},
+	}
+)
+
+// Data 比较两份原始 JSON 配置, 任意一份包含 urls 字段时按多仓配置比较
+func Data(old, new []byte) (*Result, error) {
+	for _, data := range [][]byte{old, new} {
+		if len(bytes.TrimSpace(data)) > 0 && !gjson.ValidBytes(data) {
+			return nil, fmt.Errorf("invalid JSON")
+		}
+	}
+
+	if gjson.GetBytes(old, "urls").Exists() || gjson.GetBytes(new, "urls").Exists() {
+		return compare(old, new, nil, multiRepoSections), nil
+	}
+	return compare(old, new, repoValues, repoSections), nil
+}
+
+func compare(old, new []byte, values []string, sections []section) *Result {
+	result := &Result{}
+
+	for _, field := range values {
+		o, n := gjson.GetBytes(old, field).String(), gjson.GetBytes(new, field).String()
+		if o != n {
+			if result.Values == nil {
+				result.Values = make(map[string]ValueChange)
+			}
+			result.Values[field] = ValueChange{Old: o, New: n}
+		}
+	}
+
+	for _, s := range sections {
+		changes := compareSection(gjson.GetBytes(old, s.field).Array(), gjson.GetBytes(new, s.field).Array(), s)
+		if len(changes) > 0 {
+			if result.Sections == nil {
+				result.Sections = make(map[string][]ItemChange)
+			}
+			result.Sections[s.field] = changes
+		}
+	}
+
+	return result
+}
+
+// compareSection 按 key 比较数组字段, 结果按新增、删除、修改的顺序排列
+func compareSection(old, new []gjson.Result, s section) []ItemChange {
+	itemKey := func(item gjson.Result) string {
+		if s.key == "" {
+			return item.String()
+		}
+		return item.Get(s.key).String()
+	}
+	itemName := func(item gjson.Result) string {
+		if s.name == "" {
+			return ""
+		}
+		return item.Get(s.name).String()
+	}
+
+	oldItems := make(map[string]gjson.Result, len(old))
+	for _, item := range old {
+		oldItems[itemKey(item)] = item
+	}
+	newItems := make(map[string]gjson.Result, len(new))
+	for _, item := range new {
+		newItems[itemKey(item)] = item
+	}
+
+	var added, removed, changed []ItemChange
+	for _, item := range new {
+		key := itemKey(item)
+		oldItem, ok := oldItems[key]
+		if !ok {
+			added = append(added, ItemChange{Kind: Added, Key: key, Name: itemName(item)})
+			continue
+		}
+		if fields := changedFields(oldItem, item); len(fields) > 0 {
+			changed = append(changed, ItemChange{Kind: Changed, Key: key, Name: itemName(item), Fields: fields})
+		}
+	}
+	for _, item := range old {
+		key := itemKey(item)
+		if _, ok := newItems[key]; !ok {
+			removed = append(removed, ItemChange{Kind: Removed, Key: key, Name: itemName(item)})
+		}
+	}
+
+	return append(append(added, removed...), changed...)
+}
+
+// changedFields 返回两个对象中值不同的字段名
+func changedFields(old, new gjson.Result) []string {
+	if !old.IsObject() || !new.IsObject() {
+		if old.Raw != new.Raw {
+			return []string{"value"}
+		}
+		return nil
+	}
+
+	oldFields, newFields := old.Map(), new.Map()
+	var fields []string
+	for field, value := range newFields {
+		if oldValue, ok := oldFields[field]; !ok || !jsonEqual(oldValue.Raw, value.Raw) {
+			fields = append(fields, field)
+		}
+	}
+	for field := range oldFields {
+		if _, ok := newFields[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	return fields
+}
+
+// jsonEqual 比较两个 JSON 值是否语义相等, 忽略空白与对象键顺序
+func jsonEqual(a, b string) bool {
+	if a == b {
+		return true
+	}
+	var va, vb any
+	if json.Unmarshal([]byte(a), &va) != nil || json.Unmarshal([]byte(b), &vb) != nil {
+		return false
+	}
+	ca, _ := json.Marshal(va)
+	cb, _ := json.Marshal(vb)
+	return bytes.Equal(ca, cb)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,78 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestData(t *testing.T) {
+	old := []byte(`{
+		"spider": "./spider.jar;md5;aaa",
+		"sites": [
+			{"key": "site1", "name": "Site 1", "type": 3, "api": "csp_One"},
+			{"key": "site2", "name": "Site 2", "type": 3, "api": "csp_Two", "ext": {"a": 1, "b": 2}},
+			{"key": "site3", "name": "Site 3", "type": 1, "api": "https://example.com/api"}
+		],
+		"lives": [{"name": "live1", "url": "https://example.com/live1.txt"}],
+		"flags": ["youku", "qq"]
+	}`)
+	new := []byte(`{
+		"spider": "./spider.jar;md5;bbb",
+		"sites": [
+			{"key": "site1", "name": "Site 1", "type": 3, "api": "csp_One"},
+			{"key": "site2", "name": "Site 2", "type": 3, "api": "csp_Two", "ext": {"b": 2, "a": 1}},
+			{"key": "site3", "name": "Site 3", "type": "1", "api": "https://example.com/api2"},
+			{"key": "site4", "name": "Site 4", "type": 3, "api": "csp_Four"}
+		],
+		"lives": [{"name": "live2", "url": "https://example.com/live2.txt"}],
+		"flags": ["youku", "qq"]
+	}`)
+
+	result, err := Data(old, new)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]ValueChange{"spider": {Old: "./spider.jar;md5;aaa", New: "./spider.jar;md5;bbb"}}, result.Values)
+	assert.Equal(t, []ItemChange{
+		{Kind: Added, Key: "site4", Name: "Site 4"},
+		{Kind: Changed, Key: "site3", Name: "Site 3", Fields: []string{"api", "type"}},
+	}, result.Sections["sites"])
+	assert.Equal(t, []ItemChange{
+		{Kind: Added, Key: "live2"},
+		{Kind: Removed, Key: "live1"},
+	}, result.Sections["lives"])
+	assert.NotContains(t, result.Sections, "flags")
+
+	assert.Equal(t, `spider:
+  - ./spider.jar;md5;aaa
+  + ./spider.jar;md5;bbb
+lives:
+  + live2
+  - live1
+sites:
+  + site4 (Site 4)
+  ~ site3 (Site 3): api, type
+`, result.String())
+
+	result, err = Data(old, old)
+	assert.NoError(t, err)
+	assert.True(t, result.Empty())
+	assert.Equal(t, "no changes\n", result.String())
+
+	_, err = Data(old, []byte(`{"sites": [`))
+	assert.Error(t, err)
+}
+
+func TestData_MultiRepo(t *testing.T) {
+	old := []byte(`{"urls": [
+		{"name": "repo1", "url": "https://example.com/1.json"},
+		{"name": "repo2", "url": "https://example.com/2.json"}
+	]}`)
+	new := []byte(`{"urls": [{"name": "repo1", "url": "https://mirror.example.com/1.json"}]}`)
+
+	result, err := Data(old, new)
+	assert.NoError(t, err)
+	assert.Equal(t, []ItemChange{
+		{Kind: Removed, Key: "repo2"},
+		{Kind: Changed, Key: "repo1", Fields: []string{"url"}},
+	}, result.Sections["urls"])
+}
@@ -0,0 +1,306 @@
+package export
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wayjam/tvbox-mixproxy/config"
+	"github.com/wayjam/tvbox-mixproxy/pkg/imageutil"
+	"github.com/wayjam/tvbox-mixproxy/pkg/mixer"
+)
+
+const (
+	RepoFile      = "repo.json"
+	MultiRepoFile = "multi_repo.json"
+	LogoFile      = "logo.svg"
+	WallpaperFile = "wallpaper.png"
+	JarDir        = "jars"
+	StaticDir     = "static" // static_dir 的副本, 与代理服务中 mixer.StaticPath 的路径相同
+)
+
+// Options 静态导出的参数
+type Options struct {
+	Dir     string // 导出目录
+	BaseURL string // 导出目录对外访问的地址, eg. https://example.github.io/tvbox
+}
+
+// Result 记录导出过程中写入的文件与告警信息
+type Result struct {
+	Files    []string
+	Warnings []string
+}
+
+// Export 将混合后的单仓与多仓配置及其引用的 logo、壁纸与 spider jar 写入目录,
+// 配置中指向代理服务的地址会被改写为 BaseURL 下的静态文件, 使目录可以独立托管.
+// 配置了 static_dir 时将其复制到导出目录的 static 下, 使 /static 下的地址同样可以访问
+func Export(cfg *config.Config, sourcer mixer.Sourcer, opts Options) (*Result, error) {
+	if opts.BaseURL == "" {
+		return nil, fmt.Errorf("base url is required")
+	}
+
+	// 使用静态地址作为外部访问地址混合, 之后再将代理接口改写为静态文件
+	staticCfg := *cfg
+	staticCfg.ExternalURL = strings.TrimSuffix(opts.BaseURL, "/")
+	// 静态目录无法提供资源代理, 资源保持上游地址
+	staticCfg.ResProxy.Enable = false
+
+	e := &exporter{
+		cfg:     &staticCfg,
+		sourcer: sourcer,
+		dir:     opts.Dir,
+		result:  &Result{},
+		jars:    make(map[string]string),
+	}
+
+	if err := os.MkdirAll(e.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", e.dir, err)
+	}
+	if cfg.StaticDir != "" {
+		if err := e.copyStatic(cfg.StaticDir); err != nil {
+			return nil, err
+		}
+	}
+
+	if !cfg.SingleRepoOpt.Disable {
+		repo, report, err := mixer.MixRepoWithReport(e.cfg, sourcer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to mix single repo: %w", err)
+		}
+		e.mixWarnings(report)
+
+		if err := e.rewriteRepo(repo); err != nil {
+			return nil, err
+		}
+		e.localFiles(report)
+		if err := e.writeJSON(RepoFile, repo); err != nil {
+			return nil, err
+		}
+	}
+
+	if !cfg.MultiRepoOpt.Disable {
+		multiRepo, report, err := mixer.MixMultiRepoWithReport(e.cfg, sourcer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to mix multi repo: %w", err)
+		}
+		e.mixWarnings(report)
+		e.localFiles(report)
+
+		for i := range multiRepo.Repos {
+			if !cfg.SingleRepoOpt.Disable && multiRepo.Repos[i].URL == e.url("/v1/repo") {
+				multiRepo.Repos[i].URL = e.url("/" + RepoFile)
+			}
+		}
+		if err := e.writeJSON(MultiRepoFile, multiRepo); err != nil {
+			return nil, err
+		}
+	}
+
+	return e.result, nil
+}
+
+type exporter struct {
+	cfg     *config.Config
+	sourcer mixer.Sourcer
+	dir     string
+	result  *Result
+	jars    map[string]string // 去除校验信息的 jar 地址 -> 导出后的地址
+}
+
+// url 返回静态目录下 path 对应的地址
+func (e *exporter) url(path string) string {
+	return e.cfg.ExternalURL + path
+}
+
+// rewriteRepo 将单仓配置中指向代理服务的地址改写为静态文件, 并下载 spider jar
+func (e *exporter) rewriteRepo(repo *config.RepoConfig) error {
+	if repo.Logo == e.url("/logo") {
+		if err := e.writeFile(LogoFile, []byte(imageutil.LogoSVG)); err != nil {
+			return err
+		}
+		repo.Logo = e.url("/" + LogoFile)
+	}
+
+	if strings.HasPrefix(repo.Wallpaper, e.url("/wallpaper")) {
+		if err := e.writeWallpaper(repo.Wallpaper); err != nil {
+			return err
+		}
+		repo.Wallpaper = e.url("/" + WallpaperFile)
+	}
+
+	if repo.Spider == e.url("/v1/spider") {
+		// 未配置 spider 源, 代理接口在静态目录中不存在
+		repo.Spider = ""
+	} else if strings.HasPrefix(repo.Spider, e.url("/v1/spider;")) {
+		// spider 指向代理服务缓存的 jar
+		repo.Spider = e.cachedJar(repo.Spider, e.cfg.SingleRepoOpt.Spider)
+	} else {
+		repo.Spider = e.jar(repo.Spider)
+	}
+
+	for i := range repo.Sites {
+		jar := repo.Sites[i].Jar
+		if name, ok := strings.CutPrefix(strings.Split(jar, ";")[0], e.url("/v1/spider/")); ok {
+			// 站点引用其所在源的 spider
+			name, _ = url.PathUnescape(name)
+			repo.Sites[i].Jar = e.cachedJar(jar, config.MixOpt{SourceName: name, Field: "spider"})
+		} else {
+			repo.Sites[i].Jar = e.jar(jar)
+		}
+	}
+
+	return nil
+}
+
+// mixWarnings 记录混合过程中的告警, 本地文件地址的告警在改写后按导出的结果记录
+func (e *exporter) mixWarnings(report *mixer.MixReport) {
+	local := make(map[string]bool, len(report.LocalFiles))
+	for _, s := range report.LocalFiles {
+		local[mixer.LocalFileWarning(s)] = true
+	}
+	for _, warning := range report.Warnings {
+		if !local[warning] {
+			e.result.Warnings = append(e.result.Warnings, warning)
+		}
+	}
+}
+
+// localFiles 记录导出后仍为本地文件的地址, 已写入目录的 jar 除外
+func (e *exporter) localFiles(report *mixer.MixReport) {
+	for _, s := range report.LocalFiles {
+		if exported, ok := e.jars[strings.Split(s, ";")[0]]; ok && !strings.HasPrefix(exported, "file://") {
+			continue
+		}
+		e.result.Warnings = append(e.result.Warnings, mixer.LocalFileWarning(s))
+	}
+}
+
+// copyStatic 将静态目录中的文件复制到导出目录的 static 下
+func (e *exporter) copyStatic(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to read static_dir: %w", err)
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read static_dir: %w", err)
+		}
+		return e.writeFile(filepath.Join(StaticDir, rel), data)
+	})
+}
+
+// cachedJar 将代理服务缓存的 spiderOpt 对应的 jar 写入目录并返回导出后的地址,
+// 无法获取时保留原地址并记录告警
+func (e *exporter) cachedJar(proxied string, spiderOpt config.MixOpt) string {
+	jar, err := mixer.ResolveSpiderJar(spiderOpt, e.sourcer)
+	if err != nil || jar == nil {
+		e.result.Warnings = append(e.result.Warnings, fmt.Sprintf("getting cached spider of %s: %v", spiderOpt.SourceName, err))
+		return proxied
+	}
+	if exported, ok := e.jars[jar.URL]; ok {
+		return exported
+	}
+	if exported := e.writeJar(jar.URL, jar.Data()); exported != "" {
+		return exported
+	}
+	return proxied
+}
+
+// jar 下载 spider jar 并返回导出后的地址, 下载失败时保留原地址并记录告警
+func (e *exporter) jar(spider string) string {
+	if spider == "" {
+		return ""
+	}
+
+	// 移除 URL 中可能存在的校验信息
+	uri := strings.Split(spider, ";")[0]
+	if exported, ok := e.jars[uri]; ok {
+		return exported
+	}
+
+	data, err := config.FetchData(uri)
+	if err != nil {
+		e.result.Warnings = append(e.result.Warnings, fmt.Sprintf("downloading jar %s: %v", uri, err))
+		e.jars[uri] = spider
+		return spider
+	}
+
+	if exported := e.writeJar(uri, data); exported != "" {
+		return exported
+	}
+	e.jars[uri] = spider
+	return spider
+}
+
+// writeJar 将 uri 对应的 jar 以 md5 命名写入目录并返回导出后的地址, 写入失败时记录告警并返回空字符串
+func (e *exporter) writeJar(uri string, data []byte) string {
+	sum := md5.Sum(data)
+	md5Hex := hex.EncodeToString(sum[:])
+	name := filepath.Join(JarDir, md5Hex+".jar")
+	if err := e.writeFile(name, data); err != nil {
+		e.result.Warnings = append(e.result.Warnings, err.Error())
+		return ""
+	}
+
+	exported := e.url("/"+filepath.ToSlash(name)) + ";md5;" + md5Hex
+	e.jars[uri] = exported
+	return exported
+}
+
+// writeWallpaper 按壁纸地址中的参数生成图片
+func (e *exporter) writeWallpaper(wallpaper string) error {
+	u, err := url.Parse(wallpaper)
+	if err != nil {
+		return fmt.Errorf("invalid wallpaper url: %w", err)
+	}
+	query := u.Query()
+	params := imageutil.ParseImageParams(func(key, def string) string {
+		if query.Has(key) {
+			return query.Get(key)
+		}
+		return def
+	})
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, imageutil.GenerateImage(params)); err != nil {
+		return fmt.Errorf("failed to encode wallpaper: %w", err)
+	}
+
+	return e.writeFile(WallpaperFile, buf.Bytes())
+}
+
+func (e *exporter) writeJSON(name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+	return e.writeFile(name, data)
+}
+
+func (e *exporter) writeFile(name string, data []byte) error {
+	path := filepath.Join(e.dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	e.result.Files = append(e.result.Files, name)
+	return nil
+}
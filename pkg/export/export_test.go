@@ -0,0 +1,121 @@
+package export
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/wayjam/tvbox-mixproxy/config"
+	"github.com/wayjam/tvbox-mixproxy/pkg/mixer"
+)
+
+func TestExport(t *testing.T) {
+	srcDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(srcDir, "spider.jar"), []byte("spider"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(srcDir, "single.json"), []byte(`{
+		"spider": "./spider.jar;md5;outdated",
+		"sites": [
+			{"key": "site1", "name": "Site 1", "type": 3, "api": "csp_One"},
+			{"key": "site2", "name": "Site 2", "type": 3, "api": "csp_Two", "jar": "./spider.jar"},
+			{"key": "site3", "name": "Site 3", "type": 3, "api": "csp_Three", "jar": "file:///non_existent.jar"}
+		]
+	}`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(srcDir, "multi.json"), []byte(`{
+		"urls": [{"name": "repo1", "url": "https://example.com/1.json"}]
+	}`), 0644))
+
+	cfg := &config.Config{
+		Sources: []config.Source{
+			{Name: "single", URL: "file://" + filepath.Join(srcDir, "single.json"), Type: config.SourceTypeSingle},
+			{Name: "multi", URL: "file://" + filepath.Join(srcDir, "multi.json"), Type: config.SourceTypeMulti},
+		},
+		SingleRepoOpt: config.SingleRepoOpt{
+			Spider: config.MixOpt{SourceName: "single"},
+			Sites:  config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "single"}},
+		},
+		MultiRepoOpt: config.MultiRepoOpt{
+			IncludeSingleRepo: true,
+			Repos:             []config.ArrayMixOpt{{MixOpt: config.MixOpt{SourceName: "multi"}}},
+		},
+	}
+	cfg.Fixture()
+
+	sm := mixer.NewSourceManager(cfg.Sources)
+	defer sm.Close()
+
+	dir := t.TempDir()
+	result, err := Export(cfg, sm, Options{Dir: dir, BaseURL: "https://example.github.io/tv/"})
+	assert.NoError(t, err)
+
+	// md5("spider")
+	sum := "f1a81d782dea6a19bdca383bffe68452"
+	jar := "jars/" + sum + ".jar"
+
+	assert.ElementsMatch(t, []string{LogoFile, WallpaperFile, jar, RepoFile, MultiRepoFile}, result.Files)
+	// 下载失败的 jar 保留本地地址, 同时记录本地文件的告警; 已导出的 jar 不再告警
+	assert.Len(t, result.Warnings, 2)
+	for _, warning := range result.Warnings {
+		assert.Contains(t, warning, "non_existent.jar")
+	}
+
+	var repo config.RepoConfig
+	data, err := os.ReadFile(filepath.Join(dir, RepoFile))
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(data, &repo))
+	assert.Equal(t, "https://example.github.io/tv/"+jar+";md5;"+sum, repo.Spider)
+	assert.Equal(t, "https://example.github.io/tv/logo.svg", repo.Logo)
+	assert.Equal(t, "https://example.github.io/tv/wallpaper.png", repo.Wallpaper)
+	assert.Equal(t, "", repo.Sites[0].Jar)
+	assert.Equal(t, repo.Spider, repo.Sites[1].Jar)
+	assert.Equal(t, "file:///non_existent.jar", repo.Sites[2].Jar)
+
+	var multiRepo config.MultiRepoConfig
+	data, err = os.ReadFile(filepath.Join(dir, MultiRepoFile))
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(data, &multiRepo))
+	assert.Equal(t, "https://example.github.io/tv/repo.json", multiRepo.Repos[0].URL)
+	assert.Equal(t, "https://example.com/1.json", multiRepo.Repos[1].URL)
+}
+
+func TestExport_StaticDir(t *testing.T) {
+	staticDir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(staticDir, "tv"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(staticDir, "tv", "live.txt"), []byte("#EXTM3U"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(staticDir, "tv", "single.json"), []byte(`{
+		"lives": [{"name": "live1", "url": "./live.txt"}]
+	}`), 0644))
+
+	cfg := &config.Config{
+		StaticDir: staticDir,
+		Sources: []config.Source{
+			{Name: "single", URL: "file://" + filepath.Join(staticDir, "tv", "single.json"), Type: config.SourceTypeSingle},
+		},
+		SingleRepoOpt: config.SingleRepoOpt{
+			Lives: config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "single"}},
+		},
+		MultiRepoOpt: config.MultiRepoOpt{Disable: true},
+	}
+	cfg.Fixture()
+
+	sm := mixer.NewSourceManager(cfg.Sources)
+	defer sm.Close()
+
+	dir := t.TempDir()
+	result, err := Export(cfg, sm, Options{Dir: dir, BaseURL: "https://example.github.io/tv"})
+	assert.NoError(t, err)
+	assert.Empty(t, result.Warnings)
+
+	// 静态目录复制到导出目录的 static 下, /static 下的地址指向复制的文件
+	var repo config.RepoConfig
+	data, err := os.ReadFile(filepath.Join(dir, RepoFile))
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(data, &repo))
+	assert.Equal(t, "https://example.github.io/tv/static/tv/live.txt", repo.Lives[0].URL)
+
+	data, err = os.ReadFile(filepath.Join(dir, StaticDir, "tv", "live.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "#EXTM3U", string(data))
+}
@@ -7,6 +7,15 @@ import (
 	"strconv"
 )
 
+// LogoSVG is the default logo served by the proxy
+const LogoSVG = `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="100" viewBox="0 0 100 100">
+		<rect x="10" y="20" width="80" height="60" rx="5" ry="5" fill="#333"/>
+		<rect x="15" y="25" width="70" height="50" rx="3" ry="3" fill="#4CAF50"/>
+		<circle cx="50" cy="75" r="5" fill="#333"/>
+		<line x1="30" y1="15" x2="40" y2="5" stroke="#333" stroke-width="2"/>
+		<line x1="70" y1="15" x2="60" y2="5" stroke="#333" stroke-width="2"/>
+	</svg>`
+
 // Helper function to parse color from hex string
 func ParseColor(hex string) color.Color {
 	c, err := strconv.ParseUint(hex, 16, 32)
@@ -32,6 +41,25 @@ type ImageParams struct {
 	BorderColor     color.Color
 }
 
+// ParseImageParams builds ImageParams from query-style parameters.
+// get returns the value of key, or def if the key is absent.
+func ParseImageParams(get func(key, def string) string) ImageParams {
+	width, _ := strconv.Atoi(get("width", "800"))
+	height, _ := strconv.Atoi(get("height", "600"))
+	opacity, _ := strconv.ParseFloat(get("opacity", "1.0"), 64)
+	borderWidth, _ := strconv.Atoi(get("border_width", "0"))
+
+	return ImageParams{
+		BackgroundColor: ParseColor(get("bg_color", "FFFFFF")),
+		Width:           width,
+		Height:          height,
+		Pattern:         get("pattern", "solid"),
+		Opacity:         opacity,
+		BorderWidth:     borderWidth,
+		BorderColor:     ParseColor(get("border_color", "000000")),
+	}
+}
+
 // GenerateImage creates an image based on the provided parameters
 func GenerateImage(params ImageParams) *image.RGBA {
 	img := image.NewRGBA(image.Rect(0, 0, params.Width, params.Height))
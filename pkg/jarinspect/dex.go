@@ -0,0 +1,99 @@
+package jarinspect
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// dex 文件格式见 https://source.android.com/docs/core/runtime/dex-format
+
+var dexMagic = []byte("dex\n")
+
+const (
+	dexHeaderSize      = 0x70
+	dexStringIDsSize   = 0x38
+	dexTypeIDsSize     = 0x40
+	dexClassDefsSize   = 0x60
+	dexClassDefItemLen = 32
+)
+
+var errDexTruncated = errors.New("dex file truncated")
+
+// parseDex 返回 dex 文件中 class_defs 定义的类的完整名称
+func parseDex(data []byte) ([]string, error) {
+	if len(data) < dexHeaderSize {
+		return nil, errDexTruncated
+	}
+	d := dexFile(data)
+
+	stringIDsSize, stringIDsOff := d.u32(dexStringIDsSize), d.u32(dexStringIDsSize+4)
+	typeIDsSize, typeIDsOff := d.u32(dexTypeIDsSize), d.u32(dexTypeIDsSize+4)
+	classDefsSize, classDefsOff := d.u32(dexClassDefsSize), d.u32(dexClassDefsSize+4)
+	if !d.inRange(stringIDsOff, stringIDsSize, 4) ||
+		!d.inRange(typeIDsOff, typeIDsSize, 4) ||
+		!d.inRange(classDefsOff, classDefsSize, dexClassDefItemLen) {
+		return nil, errDexTruncated
+	}
+
+	classes := make([]string, 0, classDefsSize)
+	for i := uint32(0); i < classDefsSize; i++ {
+		typeIdx := d.u32(classDefsOff + i*dexClassDefItemLen)
+		if typeIdx >= typeIDsSize {
+			return nil, fmt.Errorf("invalid type index %d", typeIdx)
+		}
+		stringIdx := d.u32(typeIDsOff + typeIdx*4)
+		if stringIdx >= stringIDsSize {
+			return nil, fmt.Errorf("invalid string index %d", stringIdx)
+		}
+		descriptor, err := d.string(d.u32(stringIDsOff + stringIdx*4))
+		if err != nil {
+			return nil, err
+		}
+		classes = append(classes, className(descriptor))
+	}
+	return classes, nil
+}
+
+type dexFile []byte
+
+func (d dexFile) u32(off uint32) uint32 {
+	return binary.LittleEndian.Uint32(d[off:])
+}
+
+// inRange 判断从 off 开始的 count 个长度为 size 的项是否都在文件内
+func (d dexFile) inRange(off, count, size uint32) bool {
+	return uint64(off)+uint64(count)*uint64(size) <= uint64(len(d))
+}
+
+// string 读取 off 处的 string_data_item: uleb128 编码的 utf-16 长度, 之后为以 0 结尾的 MUTF-8 数据
+func (d dexFile) string(off uint32) (string, error) {
+	i := uint64(off)
+	// 跳过 uleb128 长度, 最多 5 个字节
+	for n := 0; ; n++ {
+		if i >= uint64(len(d)) || n >= 5 {
+			return "", errDexTruncated
+		}
+		b := d[i]
+		i++
+		if b&0x80 == 0 {
+			break
+		}
+	}
+
+	end := i
+	for end < uint64(len(d)) && d[end] != 0 {
+		end++
+	}
+	if end >= uint64(len(d)) {
+		return "", errDexTruncated
+	}
+	return string(d[i:end]), nil
+}
+
+// className 将类型描述符 Lcom/example/Foo; 转换为 com.example.Foo
+func className(descriptor string) string {
+	name := strings.TrimSuffix(strings.TrimPrefix(descriptor, "L"), ";")
+	return strings.ReplaceAll(name, "/", ".")
+}
@@ -0,0 +1,123 @@
+// Package jarinspect 列出 spider jar 中定义的类, 用于检查 csp 站点对应的 spider 类是否存在
+package jarinspect
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SpiderPackage 为 TVBox 加载 csp 站点时查找 spider 类的包名, api 为 csp_Foo 的站点对应 SpiderPackage.Foo
+const SpiderPackage = "com.github.catvod.spider"
+
+// CSPPrefix 为 csp 站点 api 的前缀
+const CSPPrefix = "csp_"
+
+// maxDexSize 为 jar 中单个 dex 文件解压后的大小上限, 避免压缩比很高的文件占用大量内存
+const maxDexSize = 64 << 20
+
+var dexEntryRegex = regexp.MustCompile(`^classes\d*\.dex$`)
+
+// Jar 为 jar 的检查结果
+type Jar struct {
+	Classes []string // jar 中定义的类的完整名称, 如 com.github.catvod.spider.Foo, 按名称排序
+	classes map[string]bool
+}
+
+// Inspect 列出 jar 中定义的类, 包括 .class 文件以及 classes*.dex 中的类;
+// data 也可以是单独的 dex 文件
+func Inspect(data []byte) (*Jar, error) {
+	var classes []string
+
+	if bytes.HasPrefix(data, dexMagic) {
+		dexClasses, err := parseDex(data)
+		if err != nil {
+			return nil, err
+		}
+		classes = dexClasses
+	} else {
+		r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("opening jar: %w", err)
+		}
+
+		for _, f := range r.File {
+			switch {
+			case strings.HasSuffix(f.Name, ".class"):
+				name := strings.TrimSuffix(f.Name, ".class")
+				classes = append(classes, strings.ReplaceAll(name, "/", "."))
+			case dexEntryRegex.MatchString(path.Base(f.Name)) && path.Dir(f.Name) == ".":
+				dexClasses, err := readDex(f)
+				if err != nil {
+					return nil, fmt.Errorf("reading %s: %w", f.Name, err)
+				}
+				classes = append(classes, dexClasses...)
+			}
+		}
+	}
+
+	sort.Strings(classes)
+	j := &Jar{Classes: classes, classes: make(map[string]bool, len(classes))}
+	for _, class := range classes {
+		j.classes[class] = true
+	}
+	return j, nil
+}
+
+func readDex(f *zip.File) ([]string, error) {
+	if f.UncompressedSize64 > maxDexSize {
+		return nil, fmt.Errorf("dex file larger than %d bytes", maxDexSize)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	// 文件头中的大小可能与实际不符, 读取时同样限制大小
+	data, err := io.ReadAll(io.LimitReader(rc, maxDexSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDexSize {
+		return nil, fmt.Errorf("dex file larger than %d bytes", maxDexSize)
+	}
+	return parseDex(data)
+}
+
+// HasClass 判断 jar 中是否定义了完整名称为 name 的类
+func (j *Jar) HasClass(name string) bool {
+	return j.classes[name]
+}
+
+// SpiderClasses 返回 SpiderPackage 下的类名, 不含包名与内部类
+func (j *Jar) SpiderClasses() []string {
+	var names []string
+	for _, class := range j.Classes {
+		name, ok := strings.CutPrefix(class, SpiderPackage+".")
+		if ok && !strings.ContainsAny(name, ".$") {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// SpiderClass 返回 csp 站点 api 对应的 spider 类的完整名称, api 不是 csp_ 开头时返回空字符串
+func SpiderClass(api string) string {
+	name, ok := strings.CutPrefix(api, CSPPrefix)
+	if !ok || name == "" {
+		return ""
+	}
+	return SpiderPackage + "." + name
+}
+
+// HasSpider 判断 jar 中是否存在 csp 站点 api 对应的 spider 类
+func (j *Jar) HasSpider(api string) bool {
+	class := SpiderClass(api)
+	return class != "" && j.HasClass(class)
+}
@@ -0,0 +1,110 @@
+package jarinspect
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildDex 生成只包含 class_defs 及其引用的字符串与类型的最小 dex 文件
+func buildDex(descriptors ...string) []byte {
+	n := uint32(len(descriptors))
+	stringIDsOff := uint32(dexHeaderSize)
+	typeIDsOff := stringIDsOff + n*4
+	classDefsOff := typeIDsOff + n*4
+	dataOff := classDefsOff + n*dexClassDefItemLen
+
+	var stringData []byte
+	stringOffs := make([]uint32, n)
+	for i, s := range descriptors {
+		stringOffs[i] = dataOff + uint32(len(stringData))
+		stringData = append(stringData, byte(len(s)))
+		stringData = append(stringData, s...)
+		stringData = append(stringData, 0)
+	}
+
+	data := make([]byte, dataOff)
+	copy(data, "dex\n035\x00")
+	put := func(off, v uint32) { binary.LittleEndian.PutUint32(data[off:], v) }
+	put(dexStringIDsSize, n)
+	put(dexStringIDsSize+4, stringIDsOff)
+	put(dexTypeIDsSize, n)
+	put(dexTypeIDsSize+4, typeIDsOff)
+	put(dexClassDefsSize, n)
+	put(dexClassDefsSize+4, classDefsOff)
+	for i := uint32(0); i < n; i++ {
+		put(stringIDsOff+i*4, stringOffs[i])
+		put(typeIDsOff+i*4, i)
+		put(classDefsOff+i*dexClassDefItemLen, i)
+	}
+	return append(data, stringData...)
+}
+
+func buildJar(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		assert.NoError(t, err)
+		f.Write(content)
+	}
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestInspect(t *testing.T) {
+	data := buildJar(t, map[string][]byte{
+		"META-INF/MANIFEST.MF":                 []byte("Manifest-Version: 1.0\n"),
+		"com/github/catvod/spider/Plain.class": nil,
+		"classes.dex": buildDex(
+			"Lcom/github/catvod/spider/Foo;",
+			"Lcom/github/catvod/spider/Foo$1;",
+			"Lcom/github/catvod/crawler/Spider;",
+		),
+		"classes2.dex":     buildDex("Lcom/github/catvod/spider/Bar;"),
+		"assets/other.dex": buildDex("Lcom/github/catvod/spider/Ignored;"),
+	})
+
+	jar, err := Inspect(data)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"com.github.catvod.crawler.Spider",
+		"com.github.catvod.spider.Bar",
+		"com.github.catvod.spider.Foo",
+		"com.github.catvod.spider.Foo$1",
+		"com.github.catvod.spider.Plain",
+	}, jar.Classes)
+	assert.Equal(t, []string{"Bar", "Foo", "Plain"}, jar.SpiderClasses())
+
+	assert.True(t, jar.HasSpider("csp_Foo"))
+	assert.True(t, jar.HasSpider("csp_Plain"))
+	assert.False(t, jar.HasSpider("csp_Missing"))
+	assert.False(t, jar.HasSpider("csp_Ignored"))
+	assert.False(t, jar.HasSpider("Foo"))
+}
+
+func TestInspectDex(t *testing.T) {
+	jar, err := Inspect(buildDex("Lcom/github/catvod/spider/Foo;"))
+	assert.NoError(t, err)
+	assert.True(t, jar.HasSpider("csp_Foo"))
+
+	// 截断的 dex
+	_, err = Inspect(buildDex("Lcom/github/catvod/spider/Foo;")[:dexHeaderSize+10])
+	assert.Error(t, err)
+
+	_, err = Inspect([]byte("not a jar"))
+	assert.Error(t, err)
+
+	// 解压后超过大小上限的 dex
+	_, err = Inspect(buildJar(t, map[string][]byte{"classes.dex": make([]byte, maxDexSize+1)}))
+	assert.ErrorContains(t, err, "larger than")
+}
+
+func TestSpiderClass(t *testing.T) {
+	assert.Equal(t, "com.github.catvod.spider.Foo", SpiderClass("csp_Foo"))
+	assert.Empty(t, SpiderClass("csp_"))
+	assert.Empty(t, SpiderClass("https://example.com/api.php"))
+}
@@ -0,0 +1,358 @@
+// Package mirror 将单仓源及其引用的 spider jar 与资源保存到本地目录, 用于冻结一个可用的上游版本
+package mirror
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wayjam/tvbox-mixproxy/config"
+)
+
+const (
+	// Path 为镜像目录在代理服务中的路径
+	Path = "/mirror"
+
+	ConfigFile = "config.json"
+	FilesDir   = "files"
+	JarDir     = "jars"
+)
+
+// Options 镜像的参数
+type Options struct {
+	Dir     string // 镜像目录, 每个源保存在以源名称命名的子目录中
+	BaseURL string // 代理服务对外访问的地址, 镜像中的地址改写为 {BaseURL}/mirror/{name}/ 下的地址
+}
+
+// Result 记录镜像写入的文件与告警信息
+type Result struct {
+	Dir      string // 源的镜像目录
+	URL      string // 镜像配置的 file:// 地址, 可作为源地址使用
+	Files    []string
+	Warnings []string
+}
+
+// Mirror 下载单仓源的配置、spider jar 以及站点 ext/api、直播与解析中相对地址或与源同域名的资源,
+// 配置中的这些地址改写为代理服务中的镜像地址, 下载失败的资源保留上游的绝对地址.
+// 顶层的 spider 改写为镜像目录中的相对路径, 由代理服务直接读取本地文件后经 /v1/spider 提供.
+// 镜像先写入临时目录, 全部完成后再替换旧的镜像
+func Mirror(source config.Source, opts Options) (*Result, error) {
+	if opts.BaseURL == "" {
+		return nil, fmt.Errorf("base url is required")
+	}
+	if source.Type == config.SourceTypeMulti {
+		return nil, fmt.Errorf("source %s is a multi source, only single sources can be mirrored", source.Name)
+	}
+	if source.Name == "" || source.Name == "." || source.Name == ".." || strings.ContainsAny(source.Name, `/\`) {
+		return nil, fmt.Errorf("invalid source name %q for mirror directory", source.Name)
+	}
+
+	data, meta, err := config.LoadDataWithMeta(source.URL)
+	if err != nil {
+		return nil, fmt.Errorf("loading source %s: %w", source.Name, err)
+	}
+	baseURL := meta.URL
+	if baseURL == "" {
+		baseURL = source.URL
+	}
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source url: %w", err)
+	}
+
+	var repo map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&repo); err != nil {
+		return nil, fmt.Errorf("parsing source %s: %w", source.Name, err)
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", opts.Dir, err)
+	}
+	tmp, err := os.MkdirTemp(opts.Dir, "."+source.Name+".tmp-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	dir := filepath.Join(opts.Dir, source.Name)
+	m := &mirrorer{
+		base:   base,
+		prefix: strings.TrimSuffix(opts.BaseURL, "/") + Path + "/" + url.PathEscape(source.Name) + "/",
+		dir:    tmp,
+		files:  make(map[string]string),
+		jars:   make(map[string]string),
+		result: &Result{Dir: dir},
+	}
+	m.mirrorRepo(repo)
+
+	content, err := json.MarshalIndent(repo, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", ConfigFile, err)
+	}
+	if err := m.writeFile(ConfigFile, content); err != nil {
+		return nil, err
+	}
+
+	if err := replaceDir(tmp, dir); err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(filepath.Join(dir, ConfigFile))
+	if err != nil {
+		return nil, err
+	}
+	m.result.URL = "file://" + filepath.ToSlash(abs)
+	return m.result, nil
+}
+
+type mirrorer struct {
+	base   *url.URL
+	prefix string // 镜像目录对外访问的地址前缀
+	dir    string
+	files  map[string]string // 上游地址 -> 镜像后的地址
+	jars   map[string]string // 上游地址 -> 镜像中 jar 的路径与 md5
+	result *Result
+}
+
+// mirrorRepo 镜像单仓配置中的资源, spider 以外其余字段中 ./ 与 ../ 开头的相对地址改写为上游的绝对地址
+func (m *mirrorer) mirrorRepo(repo map[string]any) {
+	if spider, ok := repo["spider"].(string); ok {
+		repo["spider"] = m.jar(spider, "./")
+	}
+
+	for _, site := range objects(repo["sites"]) {
+		if jar, ok := site["jar"].(string); ok {
+			site["jar"] = m.jar(jar, m.prefix)
+		}
+		// 仅 type 3 的 api 可能是 js/py 等脚本文件, 其他类型为动态接口
+		if api, ok := site["api"].(string); ok && fmt.Sprint(site["type"]) == "3" {
+			site["api"] = m.asset(api)
+		}
+		site["ext"] = m.value(site["ext"])
+	}
+	for _, live := range objects(repo["lives"]) {
+		if uri, ok := live["url"].(string); ok {
+			live["url"] = m.asset(uri)
+		}
+	}
+	for _, parse := range objects(repo["parses"]) {
+		// 带有查询参数的解析地址为动态接口, 客户端会在末尾拼接视频地址
+		if uri, ok := parse["url"].(string); ok && !strings.Contains(uri, "?") {
+			parse["url"] = m.asset(uri)
+		}
+	}
+
+	for key, value := range repo {
+		if key != "spider" {
+			repo[key] = m.absolute(value)
+		}
+	}
+}
+
+// jar 下载 spider jar, 与源不同域名的 jar 同样下载, 镜像后的地址为 prefix 下带有实际 md5 的地址
+func (m *mirrorer) jar(spider, prefix string) string {
+	uri, ok := m.resolve(strings.Split(spider, ";")[0])
+	if !ok {
+		return spider
+	}
+	if name, ok := m.jars[uri]; ok {
+		return prefix + name
+	}
+	if mirrored, ok := m.files[uri]; ok {
+		return mirrored
+	}
+
+	data, err := config.FetchData(uri)
+	if err != nil {
+		return m.failed(uri, err)
+	}
+	sum := md5.Sum(data)
+	md5Hex := hex.EncodeToString(sum[:])
+	name := path.Join(JarDir, md5Hex+".jar")
+	if err := m.writeFile(name, data); err != nil {
+		return m.failed(uri, err)
+	}
+
+	m.jars[uri] = name + ";md5;" + md5Hex
+	return prefix + m.jars[uri]
+}
+
+// asset 下载相对地址或与源同域名的资源, 其他地址保持不变
+func (m *mirrorer) asset(ref string) string {
+	uri, ok := m.resolve(ref)
+	if !ok {
+		return ref
+	}
+	u, err := url.Parse(uri)
+	if err != nil || u.Host != m.base.Host {
+		return ref
+	}
+	if mirrored, ok := m.files[uri]; ok {
+		return mirrored
+	}
+
+	data, err := config.FetchData(uri)
+	if err != nil {
+		return m.failed(uri, err)
+	}
+	name := assetPath(u)
+	if err := m.writeFile(name, data); err != nil {
+		return m.failed(uri, err)
+	}
+
+	mirrored := m.prefix + escapePath(name)
+	m.files[uri] = mirrored
+	return mirrored
+}
+
+// value 镜像 ext 等任意 JSON 值中的资源, 递归处理对象与数组.
+// ext 中的字符串不一定是地址, 仅处理 http(s) 地址与 ./、../ 开头的相对地址
+func (m *mirrorer) value(v any) any {
+	switch v := v.(type) {
+	case string:
+		if strings.HasPrefix(v, "http://") || strings.HasPrefix(v, "https://") ||
+			strings.HasPrefix(v, "./") || strings.HasPrefix(v, "../") {
+			return m.asset(v)
+		}
+	case map[string]any:
+		for key, value := range v {
+			v[key] = m.value(value)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = m.value(value)
+		}
+	}
+	return v
+}
+
+// absolute 将 ./ 与 ../ 开头的相对地址改写为上游的绝对地址, 镜像配置不再位于上游目录下
+func (m *mirrorer) absolute(v any) any {
+	switch v := v.(type) {
+	case string:
+		if strings.HasPrefix(v, "./") || strings.HasPrefix(v, "../") {
+			if uri, ok := m.resolve(v); ok {
+				return uri
+			}
+		}
+	case map[string]any:
+		for key, value := range v {
+			v[key] = m.absolute(value)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = m.absolute(value)
+		}
+	}
+	return v
+}
+
+// failed 记录下载失败的告警, 返回上游的绝对地址
+func (m *mirrorer) failed(uri string, err error) string {
+	m.result.Warnings = append(m.result.Warnings, fmt.Sprintf("mirroring %s: %v", uri, err))
+	m.files[uri] = uri
+	return uri
+}
+
+// resolve 返回 ref 的绝对地址, ref 为 ./、../、/ 开头的相对地址或 http(s) 地址时有效;
+// file:// 地址仅在源本身为本地文件时有效, 避免上游配置引用服务器上的文件
+func (m *mirrorer) resolve(ref string) (string, bool) {
+	switch {
+	case strings.HasPrefix(ref, "http://"), strings.HasPrefix(ref, "https://"):
+		return ref, true
+	case strings.HasPrefix(ref, "file://"):
+		return ref, m.base.Scheme == "file"
+	case strings.HasPrefix(ref, "./"), strings.HasPrefix(ref, "../"),
+		strings.HasPrefix(ref, "/") && !strings.HasPrefix(ref, "//"):
+	default:
+		return "", false
+	}
+
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", false
+	}
+	resolved := m.base.ResolveReference(u)
+	if resolved.Scheme == "file" {
+		return "file://" + resolved.Path, true
+	}
+	return resolved.String(), true
+}
+
+func (m *mirrorer) writeFile(name string, data []byte) error {
+	file := filepath.Join(m.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(file), err)
+	}
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+
+	m.result.Files = append(m.result.Files, name)
+	return nil
+}
+
+// assetPath 返回资源在镜像中的路径: files/ 下与上游相同的路径, 带有查询参数时在文件名中加入参数的摘要
+func assetPath(u *url.URL) string {
+	name := strings.TrimPrefix(path.Clean("/"+u.Path), "/")
+	if name == "" || strings.HasSuffix(u.Path, "/") {
+		name = path.Join(name, "index")
+	}
+	if u.RawQuery != "" {
+		sum := sha256.Sum256([]byte(u.RawQuery))
+		ext := path.Ext(name)
+		name = strings.TrimSuffix(name, ext) + "-" + hex.EncodeToString(sum[:4]) + ext
+	}
+	return path.Join(FilesDir, name)
+}
+
+func escapePath(name string) string {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// objects 返回 JSON 数组中的对象
+func objects(v any) []map[string]any {
+	items, _ := v.([]any)
+	result := make([]map[string]any, 0, len(items))
+	for _, item := range items {
+		if object, ok := item.(map[string]any); ok {
+			result = append(result, object)
+		}
+	}
+	return result
+}
+
+// replaceDir 用 tmp 替换 dir, 旧的镜像在替换成功后删除
+func replaceDir(tmp, dir string) error {
+	old := ""
+	if _, err := os.Stat(dir); err == nil {
+		old = fmt.Sprintf("%s.old-%d", dir, time.Now().UnixNano())
+		if err := os.Rename(dir, old); err != nil {
+			return fmt.Errorf("failed to move old mirror %s: %w", dir, err)
+		}
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		if old != "" {
+			os.Rename(old, dir)
+		}
+		return fmt.Errorf("failed to write mirror %s: %w", dir, err)
+	}
+	if old != "" {
+		os.RemoveAll(old)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package mirror
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wayjam/tvbox-mixproxy/config"
+)
+
+func TestMirror(t *testing.T) {
+	jarHost := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("site jar"))
+	}))
+	defer jarHost.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tv/config.json":
+			w.Write([]byte(strings.ReplaceAll(`{
+				// 注释
+				"spider": "./spider.jar;md5;0000",
+				"wallpaper": "./bg.png",
+				"sites": [
+					{"key": "js", "type": 3, "api": "../lib/drpy.js", "ext": "./js/site.js"},
+					{"key": "csp", "type": 3, "api": "csp_Foo", "jar": "JAR_HOST/site.jar", "ext": {"url": "./ext.json?v=1", "other": "https://other.example.com/ext.json", "path": "/data/local", "n": 1}},
+					{"key": "cms", "type": 1, "api": "/api.php/provide/vod"}
+				],
+				"lives": [{"name": "live", "url": "./live.txt", "epg": "https://epg.example.com/?ch={name}"}],
+				"parses": [{"name": "web", "type": 0, "url": "./parse?url="}, {"name": "missing", "url": "./missing.html"}]
+			}`, "JAR_HOST", jarHost.URL)))
+		case "/tv/spider.jar":
+			w.Write([]byte("spider jar"))
+		case "/missing.html", "/tv/missing.html":
+			http.NotFound(w, r)
+		default:
+			w.Write([]byte(r.URL.Path))
+		}
+	}))
+	defer upstream.Close()
+
+	dir := t.TempDir()
+	source := config.Source{Name: "main", URL: upstream.URL + "/tv/config.json", Type: config.SourceTypeSingle}
+	result, err := Mirror(source, Options{Dir: dir, BaseURL: "http://box:8080/"})
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "main"), result.Dir)
+	assert.Equal(t, "file://"+filepath.ToSlash(filepath.Join(dir, "main", ConfigFile)), result.URL)
+	if assert.Len(t, result.Warnings, 1) {
+		assert.Contains(t, result.Warnings[0], "/tv/missing.html")
+	}
+
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, "main", filepath.FromSlash(name)))
+		assert.NoError(t, err)
+		return string(data)
+	}
+	var repo map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(read(ConfigFile)), &repo))
+
+	prefix := "http://box:8080/mirror/main/"
+	spiderMD5 := "b45ad89dab89bbf7b72b117f4baf184e"
+	// 顶层 spider 为镜像目录中的相对路径, 由代理服务读取本地文件
+	assert.Equal(t, "./jars/"+spiderMD5+".jar;md5;"+spiderMD5, repo["spider"])
+	assert.Equal(t, "spider jar", read("jars/"+spiderMD5+".jar"))
+	// 不在镜像范围内的相对地址改为上游的绝对地址
+	assert.Equal(t, upstream.URL+"/tv/bg.png", repo["wallpaper"])
+
+	sites := repo["sites"].([]any)
+	js := sites[0].(map[string]any)
+	assert.Equal(t, prefix+"files/lib/drpy.js", js["api"])
+	assert.Equal(t, prefix+"files/tv/js/site.js", js["ext"])
+	assert.Equal(t, "/lib/drpy.js", read("files/lib/drpy.js"))
+
+	csp := sites[1].(map[string]any)
+	assert.True(t, strings.HasPrefix(csp["jar"].(string), prefix+"jars/"))
+	ext := csp["ext"].(map[string]any)
+	assert.Regexp(t, `^`+prefix+`files/tv/ext-[0-9a-f]{8}\.json$`, ext["url"])
+	assert.Equal(t, "https://other.example.com/ext.json", ext["other"])
+	// ext 中不是地址的字符串保持不变
+	assert.Equal(t, "/data/local", ext["path"])
+	assert.Equal(t, float64(1), ext["n"])
+
+	// 非 type 3 的 api 为动态接口, 保持不变
+	assert.Equal(t, "/api.php/provide/vod", sites[2].(map[string]any)["api"])
+
+	live := repo["lives"].([]any)[0].(map[string]any)
+	assert.Equal(t, prefix+"files/tv/live.txt", live["url"])
+	assert.Equal(t, "https://epg.example.com/?ch={name}", live["epg"])
+
+	parses := repo["parses"].([]any)
+	assert.Equal(t, upstream.URL+"/tv/parse?url=", parses[0].(map[string]any)["url"])
+	assert.Equal(t, upstream.URL+"/tv/missing.html", parses[1].(map[string]any)["url"])
+
+	// 再次镜像时替换旧的镜像
+	_, err = Mirror(source, Options{Dir: dir, BaseURL: "http://box:8080"})
+	assert.NoError(t, err)
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	_, err = Mirror(config.Source{Name: "multi", URL: upstream.URL, Type: config.SourceTypeMulti}, Options{Dir: dir, BaseURL: "http://box:8080"})
+	assert.Error(t, err)
+	_, err = Mirror(config.Source{Name: "../x", URL: upstream.URL}, Options{Dir: dir, BaseURL: "http://box:8080"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,250 @@
+package mixer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultHistorySize 为每个源默认保留的历史版本数量
+const DefaultHistorySize = 5
+
+// ErrVersionNotFound 表示请求的源版本不存在
+var ErrVersionNotFound = errors.New("version not found")
+
+// ErrAmbiguousVersion 表示版本前缀匹配到多个版本
+var ErrAmbiguousVersion = errors.New("ambiguous version")
+
+const historyIndexFile = "index.json"
+
+// SourceVersion 描述源的一个历史版本
+type SourceVersion struct {
+	Hash      string    `json:"hash"`       // 数据的 sha256
+	FetchedAt time.Time `json:"fetched_at"` // 最近一次获取到该版本的时间
+	Size      int       `json:"size"`
+	Pinned    bool      `json:"pinned,omitempty"`
+}
+
+// history 保存源最近 size 个不同的版本, 按获取时间从新到旧排列.
+// dir 不为空时版本数据与索引保存在 dir 中, 重启后仍然可用
+type history struct {
+	dir      string
+	size     int
+	URL      string          `json:"url"` // 历史版本对应的源地址
+	Versions []SourceVersion `json:"versions"`
+	Pinned   string          `json:"pinned,omitempty"` // 固定使用的版本, 为空表示使用最新版本
+	data     map[string][]byte
+}
+
+// loadHistory 从缓存目录加载源的历史版本, cacheDir 为空时仅在内存中保存.
+// 缓存的历史属于其他地址时会被丢弃, 避免源地址变更后继续提供旧地址的数据
+func loadHistory(cacheDir, name, uri string, size int) (*history, error) {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+
+	h := &history{size: size, URL: uri, data: make(map[string][]byte)}
+	if cacheDir == "" {
+		return h, nil
+	}
+
+	h.dir = filepath.Join(cacheDir, "sources", url.PathEscape(name))
+	content, err := os.ReadFile(filepath.Join(h.dir, historyIndexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return h, fmt.Errorf("reading history of %s: %w", name, err)
+	}
+	if err := json.Unmarshal(content, h); err != nil {
+		return h, fmt.Errorf("parsing history of %s: %w", name, err)
+	}
+	if h.URL != uri {
+		return h, h.reset(uri)
+	}
+
+	return h, nil
+}
+
+// reset 清空历史版本并删除缓存目录中的版本数据
+func (h *history) reset(uri string) error {
+	for _, v := range h.Versions {
+		os.Remove(h.versionPath(v.Hash))
+	}
+	h.URL = uri
+	h.Versions = nil
+	h.Pinned = ""
+	return h.save()
+}
+
+// add 记录新获取到的数据, 已存在的版本会被移动到最前面
+func (h *history) add(hash string, data []byte, fetchedAt time.Time) error {
+	// 与最新版本相同时数据已经保存过, 只更新获取时间
+	if latest, ok := h.latest(); ok && latest.Hash == hash {
+		h.Versions[0].FetchedAt = fetchedAt
+		return h.save()
+	}
+
+	versions := []SourceVersion{{Hash: hash, FetchedAt: fetchedAt, Size: len(data)}}
+	for _, v := range h.Versions {
+		if v.Hash != hash {
+			versions = append(versions, v)
+		}
+	}
+
+	// 超出数量的旧版本被移除, 但新加入的版本与固定使用的版本始终保留
+	var removed []SourceVersion
+	for i := len(versions) - 1; i > 0 && len(versions) > h.size; i-- {
+		if versions[i].Hash == h.Pinned {
+			continue
+		}
+		removed = append(removed, versions[i])
+		versions = append(versions[:i], versions[i+1:]...)
+	}
+	h.Versions = versions
+
+	h.data[hash] = data
+	for _, v := range removed {
+		delete(h.data, v.Hash)
+	}
+
+	if h.dir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(h.dir, 0755); err != nil {
+		return err
+	}
+	if err := writeFileSync(h.versionPath(hash), data); err != nil {
+		return err
+	}
+	for _, v := range removed {
+		os.Remove(h.versionPath(v.Hash))
+	}
+	// 磁盘模式下只在内存中保留当前需要的数据
+	for k := range h.data {
+		if k != hash && k != h.Pinned {
+			delete(h.data, k)
+		}
+	}
+
+	return h.save()
+}
+
+// get 返回指定版本的数据
+func (h *history) get(hash string) ([]byte, error) {
+	if data, ok := h.data[hash]; ok {
+		return data, nil
+	}
+	if h.dir == "" || !h.has(hash) {
+		return nil, fmt.Errorf("%w: %s", ErrVersionNotFound, hash)
+	}
+
+	data, err := os.ReadFile(h.versionPath(hash))
+	if err != nil {
+		return nil, fmt.Errorf("reading version %s: %w", hash, err)
+	}
+	return data, nil
+}
+
+// resolve 将完整或前缀形式的版本号解析为完整的版本号
+func (h *history) resolve(prefix string) (string, error) {
+	var matched []string
+	for _, v := range h.Versions {
+		if prefix != "" && strings.HasPrefix(v.Hash, prefix) {
+			matched = append(matched, v.Hash)
+		}
+	}
+
+	switch len(matched) {
+	case 0:
+		return "", fmt.Errorf("%w: %s", ErrVersionNotFound, prefix)
+	case 1:
+		return matched[0], nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrAmbiguousVersion, prefix)
+	}
+}
+
+func (h *history) has(hash string) bool {
+	for _, v := range h.Versions {
+		if v.Hash == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// latest 返回最新的版本, 没有任何版本时返回 false
+func (h *history) latest() (SourceVersion, bool) {
+	if len(h.Versions) == 0 {
+		return SourceVersion{}, false
+	}
+	return h.Versions[0], true
+}
+
+// versions 返回所有版本并标记固定使用的版本
+func (h *history) versions() []SourceVersion {
+	versions := make([]SourceVersion, len(h.Versions))
+	for i, v := range h.Versions {
+		v.Pinned = v.Hash == h.Pinned
+		versions[i] = v
+	}
+	return versions
+}
+
+func (h *history) setPinned(hash string) error {
+	h.Pinned = hash
+	if h.dir == "" {
+		return nil
+	}
+	return h.save()
+}
+
+// save 将索引写入缓存目录
+func (h *history) save() error {
+	if h.dir == "" {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(h.dir, 0755); err != nil {
+		return err
+	}
+
+	// 先写临时文件再重命名, 避免写入中断导致索引损坏
+	tmp := filepath.Join(h.dir, historyIndexFile+".tmp")
+	if err := writeFileSync(tmp, content); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(h.dir, historyIndexFile))
+}
+
+func (h *history) versionPath(hash string) string {
+	return filepath.Join(h.dir, hash+".json")
+}
+
+// writeFileSync 写入文件并等待数据落盘, 避免进程退出或断电后缓存损坏
+func writeFileSync(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
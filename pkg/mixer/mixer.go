@@ -2,8 +2,12 @@ package mixer
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
+	"path"
 	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/gofiber/fiber/v3"
@@ -19,87 +23,172 @@ var (
 	}
 )
 
-func NewMixURLHandler(
-	mixOpt config.MixOpt, sourcer Sourcer,
-) (fiber.Handler, error) {
+// NewMixURLHandler 返回代理 mixOpt 对应地址的处理器. 地址在每次请求时按源的当前数据解析,
+// 源刷新后地址或校验信息的变化立即生效, 解析失败也只影响当次请求
+func NewMixURLHandler(mixOpt config.MixOpt, sourcer Sourcer) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		url, err := ResolveMixURL(mixOpt, sourcer)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+		if url == "" {
+			return nullHandler(c)
+		}
+
+		// 本地文件直接返回文件内容
+		if filePath, ok := strings.CutPrefix(url, "file://"); ok {
+			return c.SendFile(filePath)
+		}
+
+		return proxy.Do(c, url)
+	}
+}
+
+// ResolveMixURL 返回 mixOpt 对应的完整地址, 已移除地址中的校验信息;
+// 字段被禁用、源被禁用或源中不存在该字段时返回空字符串
+func ResolveMixURL(mixOpt config.MixOpt, sourcer Sourcer) (string, error) {
+	url, _, err := resolveMixValue(mixOpt, sourcer)
+	// 移除 URL 中可能存在的校验信息
+	return strings.Split(url, ";")[0], err
+}
+
+// resolveMixValue 与 ResolveMixURL 相同, 但保留地址中的校验信息, 同时返回提供该地址的源;
+// source_name 为 file:// 地址时源为 nil. 网络源中的 file:// 地址视为错误, 避免上游读取服务器上的文件
+func resolveMixValue(mixOpt config.MixOpt, sourcer Sourcer) (string, *Source, error) {
 	if mixOpt.Disabled || mixOpt.SourceName == "" {
-		return nullHandler, nil
+		return "", nil, nil
 	}
 
-	// 如果 source_name 以 file:// 开头，则返回一个 fiber 处理器，该处理器从文件系统中读取文件内容
+	// 如果 source_name 以 file:// 开头，则直接使用文件系统中的文件
 	if strings.HasPrefix(mixOpt.SourceName, "file://") {
-		// Extract file path from the source name
-		filePath := strings.TrimPrefix(mixOpt.SourceName, "file://")
-
-		// Return a fiber handler that serves the file content
-		return func(c fiber.Ctx) error {
-			return c.SendFile(filePath)
-		}, nil
+		return mixOpt.SourceName, nil, nil
 	}
 
 	url, source, err := mixFieldAndGetSource(mixOpt, sourcer)
 	if err != nil {
-		return nullHandler, fmt.Errorf("mixing url: %w", err)
+		return "", nil, fmt.Errorf("mixing url: %w", err)
 	}
 
-	if source.Type() != config.SourceTypeSingle {
-		return nullHandler, fmt.Errorf("source %s should be a single source", mixOpt.SourceName)
+	if source == nil {
+		// 源已被禁用
+		return "", nil, nil
 	}
 
-	if url == "" {
-		return nullHandler, nil
+	if source.Type() != config.SourceTypeSingle {
+		return "", nil, fmt.Errorf("source %s should be a single source", mixOpt.SourceName)
 	}
 
-	// 移除 URL 中可能存在的校验信息
-	url = strings.Split(url, ";")[0]
-	// 如果是相对路径，则返回一个 proxy 处理器，该处理器将请求转发到相对路径
+	// 如果是相对路径，则补全为源地址下的完整地址
 	url = fullFillURL(url, source)
+	if strings.HasPrefix(url, "file://") && !source.isLocal() {
+		return "", nil, fmt.Errorf("source %s refers to a local file, which is only allowed in local sources", source.Name())
+	}
+	return url, source, nil
+}
+
+// MixReport 记录一次混合过程中产生的告警信息
+type MixReport struct {
+	BestEffort bool              // 是否为 best-effort 模式
+	Warnings   []string          // best-effort 模式下被跳过的字段及原因, 以及 spider 缓存失败等不影响结果的问题
+	ServedBy   map[string]string // 字段 -> 实际提供该字段的源名称
+	SiteIssues []SiteIssue       // spider jar 中找不到对应类的 csp 站点, 同时记录在 Warnings 中
+	LocalFiles []string          // 结果中客户端无法访问的 file:// 地址, 同时记录在 Warnings 中
+}
 
-	return proxy.Forward(url), nil
+// LocalFileWarning 返回结果中剩余本地文件地址的告警信息
+func LocalFileWarning(s string) string {
+	return fmt.Sprintf("local file %s is not accessible to clients, put it in static_dir", s)
+}
+
+// served 记录字段实际使用的源
+func (r *MixReport) served(field string, source *Source) {
+	if source == nil {
+		return
+	}
+	if r.ServedBy == nil {
+		r.ServedBy = make(map[string]string)
+	}
+	r.ServedBy[field] = source.Name()
+}
+
+// fail 处理某个字段混合失败的情况：
+// 严格模式下直接返回错误；best-effort 模式下记录告警并跳过该字段
+func (r *MixReport) fail(field string, err error) error {
+	err = fmt.Errorf("mixing %s: %w", field, err)
+	if !r.BestEffort {
+		return err
+	}
+	r.Warnings = append(r.Warnings, err.Error())
+	return nil
 }
 
 // MixRepo 函数根据配置混合多个单仓源
 func MixRepo(
 	cfg *config.Config, sourcer Sourcer,
 ) (*config.RepoConfig, error) {
+	result, _, err := MixRepoWithReport(cfg, sourcer)
+	return result, err
+}
+
+// MixRepoWithReport 与 MixRepo 相同，同时返回混合过程中的告警信息。
+// 当 cfg.BestEffort 开启时，单个字段失败不会导致整体失败，而是跳过该字段并记录告警
+func MixRepoWithReport(
+	cfg *config.Config, sourcer Sourcer,
+) (*config.RepoConfig, *MixReport, error) {
 	result := &config.RepoConfig{
 		Wallpaper: getExternalURL(cfg) + "/wallpaper?bg_color=333333&border_width=5&border_color=666666",
 		Logo:      getExternalURL(cfg) + "/logo",
 		Spider:    getExternalURL(cfg) + "/v1/spider",
 	}
+	report := &MixReport{BestEffort: cfg.BestEffort}
 	singleRepoOpt := cfg.SingleRepoOpt
 
-	// 混合 spider 字段
+	rewriter, err := newURLRewriter(cfg)
+	if err != nil {
+		return result, report, err
+	}
+
+	// 混合 spider 字段, rawSpider 为上游的完整地址, 用于检查站点的 spider 类
+	var (
+		rawSpider    string
+		spiderSource *Source
+	)
 	if !singleRepoOpt.Spider.Disabled && singleRepoOpt.Spider.SourceName != "" {
 		spider, source, err := mixFieldAndGetSource(singleRepoOpt.Spider, sourcer)
 		if err != nil {
-			return result, fmt.Errorf("mixing spider: %w", err)
-		}
-		if spider != "" {
-			spider = fullFillURL(spider, source)
-			result.Spider = spider
+			if err = report.fail("spider", err); err != nil {
+				return result, report, err
+			}
+		} else if spider != "" {
+			report.served("spider", source)
+			rawSpider, spiderSource = fullFillURL(spider, source), source
+			result.Spider = proxySpider(cfg, rawSpider, source, "/v1/spider", sourcer)
 		}
 	}
 
-	// 混合 wallpaper 字段
+	// 混合 wallpaper 字段, 源中不存在该字段时使用默认值
 	if !singleRepoOpt.Wallpaper.Disabled && singleRepoOpt.Wallpaper.SourceName != "" {
-		wallpaper, err := mixField(singleRepoOpt.Wallpaper, sourcer)
+		wallpaper, source, err := mixFieldAndGetSource(singleRepoOpt.Wallpaper, sourcer)
 		if err != nil {
-			return result, fmt.Errorf("mixing wallpaper: %w", err)
-		}
-		if wallpaper != "" {
-			result.Wallpaper = wallpaper
+			if err = report.fail("wallpaper", err); err != nil {
+				return result, report, err
+			}
+		} else if wallpaper != "" {
+			report.served("wallpaper", source)
+			result.Wallpaper = fullFillURL(wallpaper, source)
 		}
 	}
 
-	// 混合 logo 字段
+	// 混合 logo 字段, 源中不存在该字段时使用默认值
 	if !singleRepoOpt.Logo.Disabled && singleRepoOpt.Logo.SourceName != "" {
-		logo, err := mixField(singleRepoOpt.Logo, sourcer)
+		logo, source, err := mixFieldAndGetSource(singleRepoOpt.Logo, sourcer)
 		if err != nil {
-			return result, fmt.Errorf("mixing logo: %w", err)
-		}
-		if logo != "" {
-			result.Logo = logo
+			if err = report.fail("logo", err); err != nil {
+				return result, report, err
+			}
+		} else if logo != "" {
+			report.served("logo", source)
+			result.Logo = fullFillURL(logo, source)
 		}
 	}
 
@@ -107,11 +196,42 @@ func MixRepo(
 	if !singleRepoOpt.Sites.Disabled && singleRepoOpt.Sites.SourceName != "" {
 		sites, source, err := mixArrayFieldAndGetSource[config.Site](singleRepoOpt.Sites, sourcer)
 		if err != nil {
-			return result, fmt.Errorf("mixing sites: %w", err)
+			if err = report.fail("sites", err); err != nil {
+				return result, report, err
+			}
 		}
+		report.served("sites", source)
+
+		// 站点与 spider 来自不同的源时, 未指定 jar 的 csp 站点需要使用其所在源的 spider
+		var siteSpider, siteJar string
+		ownSpider := source != nil && source.Name() != report.ServedBy["spider"]
+		if ownSpider && slices.ContainsFunc(sites, func(site config.Site) bool { return isCSPSite(site) && site.Jar == "" }) {
+			siteSpider = sourceSpider(source)
+			if siteSpider != "" {
+				siteJar = proxySpider(cfg, siteSpider, source, sourceSpiderPath(source), sourcer)
+			}
+		}
+
 		// 处理 Site 结构体的特殊字段
 		for i := range sites {
 			site := processSiteFields(sites[i], source)
+			if isCSPSite(site) {
+				spider, jarSource := site.Jar, source
+				if spider == "" && ownSpider {
+					spider, site.Jar = siteSpider, siteJar
+				} else if spider == "" {
+					spider, jarSource = rawSpider, spiderSource
+				}
+
+				// 检查 spider 类是否存在, 不存在时按配置丢弃站点
+				if issue := checkSite(site, spider, jarSource, sourcer, false); issue != nil {
+					report.SiteIssues = append(report.SiteIssues, *issue)
+					report.Warnings = append(report.Warnings, issue.String())
+					if cfg.SiteCheck.DropMissing {
+						continue
+					}
+				}
+			}
 			result.Sites = append(result.Sites, site)
 		}
 	}
@@ -120,8 +240,11 @@ func MixRepo(
 	if !singleRepoOpt.DOH.Disabled && singleRepoOpt.DOH.SourceName != "" {
 		doh, source, err := mixArrayFieldAndGetSource[config.DOH](singleRepoOpt.DOH, sourcer)
 		if err != nil {
-			return result, fmt.Errorf("mixing doh: %w", err)
+			if err = report.fail("doh", err); err != nil {
+				return result, report, err
+			}
 		}
+		report.served("doh", source)
 		// 处理 DOH 结构体的特殊字段
 		for i := range doh {
 			dohItem := processDOHFields(doh[i], source)
@@ -133,8 +256,11 @@ func MixRepo(
 	if !singleRepoOpt.Lives.Disabled && singleRepoOpt.Lives.SourceName != "" {
 		lives, source, err := mixArrayFieldAndGetSource[config.Live](singleRepoOpt.Lives, sourcer)
 		if err != nil {
-			return result, fmt.Errorf("mixing lives: %w", err)
+			if err = report.fail("lives", err); err != nil {
+				return result, report, err
+			}
 		}
+		report.served("lives", source)
 		// 处理 Site 结构体的特殊字段
 		for i := range lives {
 			live := processLiveFields(lives[i], source)
@@ -146,8 +272,11 @@ func MixRepo(
 	if !singleRepoOpt.Parses.Disabled && singleRepoOpt.Parses.SourceName != "" {
 		parses, source, err := mixArrayFieldAndGetSource[config.Parse](singleRepoOpt.Parses, sourcer)
 		if err != nil {
-			return result, fmt.Errorf("mixing parses: %w", err)
+			if err = report.fail("parses", err); err != nil {
+				return result, report, err
+			}
 		}
+		report.served("parses", source)
 		// 处理 Parse 结构体的特殊字段
 		for i := range parses {
 			parse := processParseFields(parses[i], source)
@@ -157,32 +286,51 @@ func MixRepo(
 
 	// 混合 flags 数组
 	if !singleRepoOpt.Flags.Disabled && singleRepoOpt.Flags.SourceName != "" {
-		flags, err := mixArrayField[string](singleRepoOpt.Flags, sourcer)
+		flags, source, err := mixArrayFieldAndGetSource[string](singleRepoOpt.Flags, sourcer)
 		if err != nil {
-			return result, fmt.Errorf("mixing flags: %w", err)
+			if err = report.fail("flags", err); err != nil {
+				return result, report, err
+			}
 		}
+		report.served("flags", source)
 		result.Flags = flags
 	}
 
 	// 混合 rules 数组
 	if !singleRepoOpt.Rules.Disabled && singleRepoOpt.Rules.SourceName != "" {
-		rules, err := mixArrayField[config.Rule](singleRepoOpt.Rules, sourcer)
+		rules, source, err := mixArrayFieldAndGetSource[config.Rule](singleRepoOpt.Rules, sourcer)
 		if err != nil {
-			return result, fmt.Errorf("mixing rules: %w", err)
+			if err = report.fail("rules", err); err != nil {
+				return result, report, err
+			}
+		}
+		report.served("rules", source)
+		for i := range rules {
+			rules[i] = processRuleFields(rules[i], source)
 		}
 		result.Rules = rules
 	}
 
 	// 混合 ads 数组
 	if !singleRepoOpt.Ads.Disabled && singleRepoOpt.Ads.SourceName != "" {
-		ads, err := mixArrayField[string](singleRepoOpt.Ads, sourcer)
+		ads, source, err := mixArrayFieldAndGetSource[string](singleRepoOpt.Ads, sourcer)
 		if err != nil {
-			return result, fmt.Errorf("mixing ads: %w", err)
+			if err = report.fail("ads", err); err != nil {
+				return result, report, err
+			}
 		}
+		report.served("ads", source)
 		result.Ads = ads
 	}
 
-	return result, nil
+	// 改写所有地址, 检查 spider 类与缓存 jar 时仍使用上游的原始地址
+	rewriter.repo(result)
+	rewriter.report(report)
+
+	// 最后将选定字段改为资源代理的签名地址, 签名的是改写后的地址, 代理服务也从改写后的地址下载
+	newResProxy(cfg).repo(result)
+
+	return result, report, nil
 }
 
 // mixField 混合单个字段
@@ -195,20 +343,42 @@ func mixField(opt config.MixOpt, sourcer Sourcer) (string, error) {
 	return value, nil
 }
 
-// mixFieldAndGetSource 混合单个字段并返回源
+// mixFieldAndGetSource 混合单个字段并返回实际提供该字段的源.
+// 依次尝试主源与降级源, 直到某个源的字段存在且非空
 func mixFieldAndGetSource(opt config.MixOpt, sourcer Sourcer) (string, *Source, error) {
-	source, err := sourcer.GetSource(opt.SourceName)
-	if err != nil {
-		return "", nil, fmt.Errorf("getting source %s: %w", opt.SourceName, err)
+	var (
+		firstSource *Source
+		firstErr    error
+	)
+
+	for _, name := range opt.SourceNames() {
+		source, err := sourcer.GetSource(name)
+		if errors.Is(err, ErrSourceDisabled) {
+			// 源被禁用时视为字段不存在
+			continue
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("getting source %s: %w", name, err)
+			}
+			continue
+		}
+		if firstSource == nil {
+			firstSource = source
+		}
+
+		value := gjson.GetBytes(source.Data(), opt.Field)
+		if value.Exists() && value.String() != "" {
+			return value.String(), source, nil
+		}
 	}
 
-	value := gjson.GetBytes(source.Data(), opt.Field)
-	if !value.Exists() {
+	if firstSource != nil {
 		// 如果字段不存在，返回空字符串而不是错误
-		return "", source, nil
+		return "", firstSource, nil
 	}
 
-	return value.String(), source, nil
+	return "", nil, firstErr
 }
 
 // mixArrayField 混合数组字段
@@ -221,21 +391,64 @@ func mixArrayField[T any](opt config.ArrayMixOpt, sourcer Sourcer) ([]T, error)
 	return array, nil
 }
 
+// mixArrayFieldAndGetSource 混合数组字段并返回实际提供该字段的源.
+// 依次尝试主源与降级源, 直到某个源的字段过滤后非空
 func mixArrayFieldAndGetSource[T any](opt config.ArrayMixOpt, sourcer Sourcer) ([]T, *Source, error) {
-	source, err := sourcer.GetSource(opt.SourceName)
-	if err != nil {
-		return nil, nil, fmt.Errorf("getting source %s: %w", opt.SourceName, err)
+	var (
+		firstSource *Source
+		firstErr    error
+	)
+
+	for _, name := range opt.SourceNames() {
+		source, err := sourcer.GetSource(name)
+		if errors.Is(err, ErrSourceDisabled) {
+			// 源被禁用时视为字段不存在
+			continue
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("getting source %s: %w", name, err)
+			}
+			continue
+		}
+
+		result, err := decodeArrayField[T](source, opt)
+		if err != nil {
+			// 解析失败同样降级到下一个源
+			if firstErr == nil {
+				firstErr = fmt.Errorf("decoding %s of source %s: %w", opt.Field, name, err)
+			}
+			continue
+		}
+		if len(result) > 0 {
+			return result, source, nil
+		}
+		if firstSource == nil {
+			firstSource = source
+		}
+	}
+
+	if firstSource != nil {
+		// 如果字段不存在或不是数组，返回空切片而不是错误
+		return []T{}, firstSource, nil
+	}
+	if firstErr != nil {
+		return nil, nil, firstErr
 	}
 
+	return []T{}, nil, nil
+}
+
+// decodeArrayField 从源中读取数组字段, 过滤后解析为 T
+func decodeArrayField[T any](source *Source, opt config.ArrayMixOpt) ([]T, error) {
 	array := gjson.GetBytes(source.Data(), opt.Field)
 	if !array.Exists() || !array.IsArray() {
-		// 如果字段不存在或不是数组，返回空切片而不是错误
-		return []T{}, source, nil
+		return nil, nil
 	}
 
 	filteredArray, err := filterArray(array.Array(), opt)
 	if err != nil {
-		return nil, source, fmt.Errorf("filtering array: %w", err)
+		return nil, fmt.Errorf("filtering array: %w", err)
 	}
 
 	var result []T
@@ -243,12 +456,12 @@ func mixArrayFieldAndGetSource[T any](opt config.ArrayMixOpt, sourcer Sourcer) (
 		var t T
 		err := json.Unmarshal([]byte(item.Raw), &t)
 		if err != nil {
-			return nil, source, fmt.Errorf("unmarshal error: %w", err)
+			return nil, fmt.Errorf("unmarshal error: %w", err)
 		}
 		result = append(result, t)
 	}
 
-	return result, source, nil
+	return result, nil
 }
 
 // filterArray 根据配置过滤数组
@@ -289,11 +502,52 @@ func filterArray(array []gjson.Result, opt config.ArrayMixOpt) ([]gjson.Result,
 	return result, nil
 }
 
+// PreviewFilter 按 opt 的 include/exclude 过滤 data 中的数组字段,
+// 分别返回会被保留与被剔除的项, 与混合时使用相同的过滤逻辑
+func PreviewFilter(data []byte, opt config.ArrayMixOpt) (kept, dropped []gjson.Result, err error) {
+	array := gjson.GetBytes(data, opt.Field)
+	if !array.Exists() || !array.IsArray() {
+		return nil, nil, nil
+	}
+
+	items := array.Array()
+	kept, err = filterArray(items, opt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// kept 是 items 保持顺序的子序列
+	j := 0
+	for _, item := range items {
+		if j < len(kept) && kept[j].Raw == item.Raw {
+			j++
+			continue
+		}
+		dropped = append(dropped, item)
+	}
+
+	return kept, dropped, nil
+}
+
 // MixMultiRepo 函数根据配置混合多个多仓源
 func MixMultiRepo(
 	cfg *config.Config, sourcer Sourcer,
 ) (*config.MultiRepoConfig, error) {
+	result, _, err := MixMultiRepoWithReport(cfg, sourcer)
+	return result, err
+}
+
+// MixMultiRepoWithReport 与 MixMultiRepo 相同，同时返回混合过程中的告警信息
+func MixMultiRepoWithReport(
+	cfg *config.Config, sourcer Sourcer,
+) (*config.MultiRepoConfig, *MixReport, error) {
 	multiRepoOpt := cfg.MultiRepoOpt
+	report := &MixReport{BestEffort: cfg.BestEffort}
+
+	rewriter, err := newURLRewriter(cfg)
+	if err != nil {
+		return nil, report, err
+	}
 
 	result := &config.MultiRepoConfig{
 		Repos: make([]config.RepoURLConfig, 0),
@@ -307,20 +561,27 @@ func MixMultiRepo(
 		})
 	}
 
-	for _, repoMixOpt := range multiRepoOpt.Repos {
+	for i, repoMixOpt := range multiRepoOpt.Repos {
 		if !repoMixOpt.Disabled {
 			repos, source, err := mixArrayFieldAndGetSource[config.RepoURLConfig](repoMixOpt, sourcer)
 			if err != nil {
-				return result, fmt.Errorf("mixing repos: %w", err)
+				if err = report.fail("repos from "+repoMixOpt.SourceName, err); err != nil {
+					return result, report, err
+				}
+				continue
 			}
-			for i := range repos {
-				repo := processMultiRepoFields(repos[i], source)
+			report.served(fmt.Sprintf("repos[%d]", i), source)
+			for j := range repos {
+				repo := processMultiRepoFields(repos[j], source)
 				result.Repos = append(result.Repos, repo)
 			}
 		}
 	}
 
-	return result, nil
+	rewriter.multiRepo(result)
+	rewriter.report(report)
+
+	return result, report, nil
 }
 
 func getExternalURL(cfg *config.Config) (url string) {
@@ -332,65 +593,113 @@ func getExternalURL(cfg *config.Config) (url string) {
 	return
 }
 
-// fullFillURL 将相对路径转换为绝对路径
-func fullFillURL(url string, source *Source) string {
-	if strings.HasPrefix(url, "./") {
-		baseURL := source.URL()
-		lastSlashIndex := strings.LastIndex(baseURL, "/")
-		if lastSlashIndex != -1 {
-			baseURL = baseURL[:lastSlashIndex+1]
-		}
-		url = baseURL + strings.TrimPrefix(url, "./")
+// fullFillURL 按 RFC 3986 将相对地址解析为源地址下的绝对地址, 源地址经过重定向时使用最终地址.
+// 仅处理 ./、../ 与 / 开头的地址, 其他值 (绝对地址、csp_ 类名、普通文本等) 保持不变
+func fullFillURL(ref string, source *Source) string {
+	if source == nil || !isRelativeRef(ref) {
+		return ref
 	}
+	return resolveURL(source.BaseURL(), ref)
+}
 
-	return url
+// isRelativeRef 判断 s 是否为需要解析的相对地址, // 开头的协议相对地址与 /* 开头的注释除外
+func isRelativeRef(s string) bool {
+	switch {
+	case strings.HasPrefix(s, "./"), strings.HasPrefix(s, "../"):
+		return true
+	case strings.HasPrefix(s, "/"):
+		return !strings.HasPrefix(s, "//") && !strings.HasPrefix(s, "/*")
+	}
+	return false
 }
 
-func processSiteFields(item config.Site, source *Source) config.Site {
-	if strings.HasPrefix(item.API, "./") {
-		item.API = fullFillURL(item.API, source)
+// resolveURL 以 base 为基准解析 ref, 无法解析时返回 ref
+func resolveURL(base, ref string) string {
+	// file://./a.json 等相对路径的本地文件不是合法的 file URL, 按路径解析
+	if localPath, ok := strings.CutPrefix(base, "file://"); ok && !strings.HasPrefix(localPath, "/") {
+		if !strings.HasPrefix(ref, "/") {
+			ref = path.Join(path.Dir(localPath), ref)
+		}
+		return "file://" + ref
 	}
 
-	if strings.HasPrefix(item.Jar, "./") {
-		item.Jar = fullFillURL(item.Jar, source)
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return ref
 	}
 
-	switch ext := item.Ext.(type) {
-	case string:
-		if strings.HasPrefix(ext, "./") {
-			item.Ext = fullFillURL(ext, source)
+	resolved := baseURL.ResolveReference(refURL)
+	if resolved.Scheme == "file" {
+		// 本地文件直接按路径读取, 保留未转义的路径
+		return "file://" + resolved.Path
+	}
+	if strings.ContainsAny(ref, "{}") {
+		// 保留 {name} 等由客户端替换的占位符
+		return templateUnescaper.Replace(resolved.String())
+	}
+	return resolved.String()
+}
+
+var templateUnescaper = strings.NewReplacer("%7B", "{", "%7D", "}")
+
+// fullFillExt 解析 ext 中的相对地址. ext 可能是任意配置或代码,
+// 仅处理整体为地址的字符串: ./ 与 ../ 开头, 或 / 开头且带有文件扩展名的路径
+func fullFillExt(ext any, source *Source) any {
+	s, ok := ext.(string)
+	if !ok || !isRelativeRef(s) {
+		return ext
+	}
+	if strings.HasPrefix(s, "/") {
+		p, _, _ := strings.Cut(s, "?")
+		if strings.ContainsAny(s, " \t\n") || !fileExtRegex.MatchString(path.Ext(p)) {
+			return ext
 		}
 	}
+	return fullFillURL(s, source)
+}
+
+var fileExtRegex = regexp.MustCompile(`^\.[A-Za-z0-9]+$`)
 
+func processSiteFields(item config.Site, source *Source) config.Site {
+	item.API = fullFillURL(item.API, source)
+	item.Jar = fullFillURL(item.Jar, source)
+	item.Ext = fullFillExt(item.Ext, source)
 	return item
 }
 
 func processLiveFields(item config.Live, source *Source) config.Live {
-	if strings.HasPrefix(item.URL, "./") {
-		item.URL = fullFillURL(item.URL, source)
-	}
-
+	item.URL = fullFillURL(item.URL, source)
+	item.EPG = fullFillURL(item.EPG, source)
+	item.Logo = fullFillURL(item.Logo, source)
 	return item
 }
 
 func processMultiRepoFields(item config.RepoURLConfig, source *Source) config.RepoURLConfig {
-	if strings.HasPrefix(item.URL, "./") {
-		item.URL = fullFillURL(item.URL, source)
-	}
-
+	item.URL = fullFillURL(item.URL, source)
 	return item
 }
 
 func processDOHFields(item config.DOH, source *Source) config.DOH {
-	if strings.HasPrefix(item.URL, "./") {
-		item.URL = fullFillURL(item.URL, source)
-	}
+	item.URL = fullFillURL(item.URL, source)
 	return item
 }
 
 func processParseFields(item config.Parse, source *Source) config.Parse {
-	if strings.HasPrefix(item.URL, "./") {
-		item.URL = fullFillURL(item.URL, source)
+	item.URL = fullFillURL(item.URL, source)
+	item.Ext = fullFillExt(item.Ext, source)
+	return item
+}
+
+// processRuleFields 解析规则中以相对地址引用的脚本, 脚本也可能是 / 开头的 js 代码, 仅解析 ./ 与 ../ 开头的地址
+func processRuleFields(item config.Rule, source *Source) config.Rule {
+	for i, script := range item.Script {
+		if strings.HasPrefix(script, "./") || strings.HasPrefix(script, "../") {
+			item.Script[i] = fullFillURL(script, source)
+		}
 	}
 	return item
 }
@@ -83,8 +83,8 @@ func TestMixRepo_FieldNotExist(t *testing.T) {
 	result, err := MixRepo(cfg, mockSourcer)
 	assert.NoError(t, err)
 	assert.Equal(t, "http://localhost:0/v1/spider", result.Spider)
-	assert.Equal(t, "", result.Wallpaper)
-	assert.Equal(t, "", result.Logo)
+	assert.Equal(t, "http://localhost:0/wallpaper?bg_color=333333&border_width=5&border_color=666666", result.Wallpaper)
+	assert.Equal(t, "http://localhost:0/logo", result.Logo)
 	assert.Empty(t, result.Sites)
 	assert.Empty(t, result.DOH)
 	assert.Empty(t, result.Lives)
@@ -252,3 +252,225 @@ func TestMixMultiRepo(t *testing.T) {
 	assert.NotNil(t, result)
 	assert.Len(t, result.Repos, 2) // 1 from single repo + 1 from existing multi_source
 }
+
+func TestMixRepo_BestEffort(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"source1": {
+				data: []byte(`{"spider":"spider1","sites":[{"key":"site1","name":"Site 1"}],"lives":[{"name":"live1"}]}`),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		SingleRepoOpt: config.SingleRepoOpt{
+			Spider: config.MixOpt{SourceName: "source1", Field: "spider"},
+			Sites:  config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "broken", Field: "sites"}},
+			Lives:  config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "source1", Field: "lives"}},
+		},
+	}
+
+	// 严格模式下任一字段失败则整体失败
+	_, err := MixRepo(cfg, mockSourcer)
+	assert.Error(t, err)
+
+	// best-effort 模式下仅跳过失败的字段
+	cfg.BestEffort = true
+	result, report, err := MixRepoWithReport(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Equal(t, "spider1", result.Spider)
+	assert.Empty(t, result.Sites)
+	assert.Len(t, result.Lives, 1)
+	assert.Len(t, report.Warnings, 1)
+	assert.Contains(t, report.Warnings[0], "mixing sites")
+}
+
+func TestMixRepo_Fallbacks(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"empty": {
+				config: config.Source{Name: "empty"},
+				data:   []byte(`{"sites":[{"key":"adult_1","name":"Adult"}]}`),
+			},
+			"backup": {
+				config: config.Source{Name: "backup"},
+				data:   []byte(`{"spider":"spider_backup","sites":[{"key":"site2","name":"Site 2"}],"lives":[{"name":"live2"}]}`),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		SingleRepoOpt: config.SingleRepoOpt{
+			// 主源不存在
+			Spider: config.MixOpt{SourceName: "broken", Fallbacks: []string{"backup"}, Field: "spider"},
+			// 主源过滤后为空
+			Sites: config.ArrayMixOpt{
+				MixOpt:   config.MixOpt{SourceName: "empty", Fallbacks: []string{"broken", "backup"}, Field: "sites"},
+				FilterBy: "key",
+				Exclude:  "^adult_",
+			},
+			// 主源缺少该字段
+			Lives: config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "empty", Fallbacks: []string{"backup"}, Field: "lives"}},
+		},
+	}
+
+	result, report, err := MixRepoWithReport(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Equal(t, "spider_backup", result.Spider)
+	assert.Len(t, result.Sites, 1)
+	assert.Equal(t, "site2", result.Sites[0].Key)
+	assert.Len(t, result.Lives, 1)
+	assert.Equal(t, map[string]string{"spider": "backup", "sites": "backup", "lives": "backup"}, report.ServedBy)
+
+	// 所有源都失败时返回主源的错误
+	cfg.SingleRepoOpt.Spider = config.MixOpt{SourceName: "broken", Fallbacks: []string{"broken2"}, Field: "spider"}
+	_, err = MixRepo(cfg, mockSourcer)
+	assert.ErrorContains(t, err, "getting source broken:")
+}
+
+func TestMixRepo_FallbackOnDecodeError(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"malformed": {
+				config: config.Source{Name: "malformed"},
+				data:   []byte(`{"sites":[1,"site"]}`),
+			},
+			"backup": {
+				config: config.Source{Name: "backup"},
+				data:   []byte(`{"sites":[{"key":"site2","name":"Site 2"}]}`),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		SingleRepoOpt: config.SingleRepoOpt{
+			Sites: config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "malformed", Fallbacks: []string{"backup"}, Field: "sites"}},
+		},
+	}
+
+	result, report, err := MixRepoWithReport(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Len(t, result.Sites, 1)
+	assert.Equal(t, "site2", result.Sites[0].Key)
+	assert.Equal(t, "backup", report.ServedBy["sites"])
+
+	// 所有源都无法解析时返回错误
+	cfg.SingleRepoOpt.Sites.Fallbacks = nil
+	_, err = MixRepo(cfg, mockSourcer)
+	assert.ErrorContains(t, err, "decoding sites of source malformed:")
+}
+
+func TestPreviewFilter(t *testing.T) {
+	data := []byte(`{"sites":[{"key":"site1"},{"key":"adult_1"},{"key":"site2"}]}`)
+
+	kept, dropped, err := PreviewFilter(data, config.ArrayMixOpt{
+		MixOpt:   config.MixOpt{Field: "sites"},
+		FilterBy: "key",
+		Exclude:  "^adult_",
+	})
+	assert.NoError(t, err)
+	assert.Len(t, kept, 2)
+	assert.Equal(t, "site1", kept[0].Get("key").String())
+	assert.Equal(t, "site2", kept[1].Get("key").String())
+	assert.Len(t, dropped, 1)
+	assert.Equal(t, "adult_1", dropped[0].Get("key").String())
+
+	_, _, err = PreviewFilter(data, config.ArrayMixOpt{
+		MixOpt:  config.MixOpt{Field: "sites"},
+		Include: "(",
+	})
+	assert.Error(t, err)
+}
+
+func TestFullFillURL(t *testing.T) {
+	httpSource := &Source{config: config.Source{URL: "https://example.com/tv/a/config.json?v=1"}}
+	fileSource := &Source{config: config.Source{URL: "file:///data/tv/config.json"}}
+	relFileSource := &Source{config: config.Source{URL: "file://./tv/config.json"}}
+	redirected := &Source{
+		config:  config.Source{URL: "https://short.example/c"},
+		baseURL: "https://cdn.example.com/repo/config.json",
+	}
+
+	tests := []struct {
+		source *Source
+		ref    string
+		want   string
+	}{
+		{httpSource, "./spider.jar;md5;abc", "https://example.com/tv/a/spider.jar;md5;abc"},
+		{httpSource, "../lib/spider.jar", "https://example.com/tv/lib/spider.jar"},
+		{httpSource, "../../../x.json", "https://example.com/x.json"},
+		{httpSource, "/root.json", "https://example.com/root.json"},
+		{httpSource, "./ext.json?a=1#top", "https://example.com/tv/a/ext.json?a=1#top"},
+		{httpSource, "//other.example/x.json", "//other.example/x.json"},
+		{httpSource, "https://other.example/x.json", "https://other.example/x.json"},
+		{httpSource, "csp_Foo", "csp_Foo"},
+		{httpSource, "", ""},
+		{fileSource, "./spider.jar", "file:///data/tv/spider.jar"},
+		{fileSource, "../lib/spider.jar", "file:///data/lib/spider.jar"},
+		{fileSource, "/opt/spider.jar", "file:///opt/spider.jar"},
+		{relFileSource, "./spider.jar", "file://tv/spider.jar"},
+		{relFileSource, "../spider.jar", "file://spider.jar"},
+		{redirected, "./spider.jar", "https://cdn.example.com/repo/spider.jar"},
+		{nil, "./spider.jar", "./spider.jar"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, fullFillURL(tt.ref, tt.source), tt.ref)
+	}
+}
+
+func TestMixRepo_RelativeURLs(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"source1": {
+				config: config.Source{Name: "source1", URL: "https://example.com/tv/config.json"},
+				data: []byte(`{"wallpaper":"../wall.jpg","logo":"/logo.png",
+					"sites":[{"key":"site1","api":"./api.py","ext":{"rule":"./rule.json","list":["../a.json","text"],"n":1}},
+						{"key":"site2","api":"csp_Foo","ext":"./ext.json"},
+						{"key":"site3","api":"csp_Foo","ext":"/ext/site3.json?v=1"},
+						{"key":"site4","api":"csp_Foo","ext":"/x/.test(location.href)"}],
+					"lives":[{"name":"live1","url":"./live.txt","epg":"../epg.xml","logo":"/logo/{name}.png"}],
+					"parses":[{"name":"p1","url":"./parse?url=","ext":{"flag":["qq"],"header":{"ref":"./ref"}}}],
+					"rules":[{"name":"r1","hosts":["a.com"],"script":["./r1.js","/x/.test(location.href)"]}]}`),
+			},
+		},
+	}
+
+	opt := config.MixOpt{SourceName: "source1"}
+	array := func(field string) config.ArrayMixOpt {
+		return config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "source1", Field: field}}
+	}
+	cfg := &config.Config{
+		SingleRepoOpt: config.SingleRepoOpt{
+			Wallpaper: config.MixOpt{SourceName: opt.SourceName, Field: "wallpaper"},
+			Logo:      config.MixOpt{SourceName: opt.SourceName, Field: "logo"},
+			Sites:     array("sites"),
+			Lives:     array("lives"),
+			Parses:    array("parses"),
+			Rules:     array("rules"),
+		},
+	}
+
+	result, err := MixRepo(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/wall.jpg", result.Wallpaper)
+	assert.Equal(t, "https://example.com/logo.png", result.Logo)
+	assert.Equal(t, "https://example.com/tv/api.py", result.Sites[0].API)
+	// 对象与数组形式的 ext 保持不变, 仅解析整体为地址的字符串
+	assert.Equal(t, map[string]any{
+		"rule": "./rule.json",
+		"list": []any{"../a.json", "text"},
+		"n":    float64(1),
+	}, result.Sites[0].Ext)
+	assert.Equal(t, "https://example.com/tv/ext.json", result.Sites[1].Ext)
+	assert.Equal(t, "https://example.com/ext/site3.json?v=1", result.Sites[2].Ext)
+	assert.Equal(t, "/x/.test(location.href)", result.Sites[3].Ext)
+	assert.Equal(t, "https://example.com/tv/live.txt", result.Lives[0].URL)
+	assert.Equal(t, "https://example.com/epg.xml", result.Lives[0].EPG)
+	assert.Equal(t, "https://example.com/logo/{name}.png", result.Lives[0].Logo)
+	assert.Equal(t, "https://example.com/tv/parse?url=", result.Parses[0].URL)
+	assert.Equal(t, map[string]any{
+		"flag":   []any{"qq"},
+		"header": map[string]any{"ref": "./ref"},
+	}, result.Parses[0].Ext)
+	assert.Equal(t, []string{"https://example.com/tv/r1.js", "/x/.test(location.href)"}, result.Rules[0].Script)
+}
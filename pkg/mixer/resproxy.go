@@ -0,0 +1,86 @@
+package mixer
+
+import (
+	"strings"
+
+	"github.com/wayjam/tvbox-mixproxy/config"
+	"github.com/wayjam/tvbox-mixproxy/pkg/resproxy"
+)
+
+// resProxy 将 res_proxy.fields 选定字段中的上游地址改为经 /v1/res 代理访问的签名地址
+type resProxy struct {
+	base   string
+	secret string
+	fields map[string]bool
+}
+
+// newResProxy 未启用资源代理或未选定字段时返回 nil
+func newResProxy(cfg *config.Config) *resProxy {
+	opt := cfg.ResProxy
+	if !opt.Enable || opt.Secret == "" || len(opt.Fields) == 0 {
+		return nil
+	}
+
+	p := &resProxy{
+		base:   getExternalURL(cfg),
+		secret: opt.Secret,
+		fields: make(map[string]bool, len(opt.Fields)),
+	}
+	for _, field := range opt.Fields {
+		p.fields[field] = true
+	}
+	return p
+}
+
+// proxy 返回 s 经代理访问的地址; 不是 http(s) 地址、已指向代理服务,
+// 或包含 {name} 等由客户端替换的占位符时保持不变
+func (p *resProxy) proxy(s string) string {
+	if !strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "https://") {
+		return s
+	}
+	if strings.HasPrefix(s, p.base+"/") || strings.ContainsAny(s, "{}") {
+		return s
+	}
+	return resproxy.URL(p.base, p.secret, s)
+}
+
+// proxyValue 代理 ext 等任意 JSON 值中的地址, 递归处理对象与数组
+func (p *resProxy) proxyValue(v any) any {
+	switch v := v.(type) {
+	case string:
+		return p.proxy(v)
+	case map[string]any:
+		for key, value := range v {
+			v[key] = p.proxyValue(value)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = p.proxyValue(value)
+		}
+	}
+	return v
+}
+
+// repo 代理单仓配置中选定字段的地址
+func (p *resProxy) repo(repo *config.RepoConfig) {
+	if p == nil {
+		return
+	}
+
+	if p.fields["wallpaper"] {
+		repo.Wallpaper = p.proxy(repo.Wallpaper)
+	}
+	if p.fields["logo"] {
+		repo.Logo = p.proxy(repo.Logo)
+	}
+	if p.fields["sites.ext"] {
+		for i := range repo.Sites {
+			repo.Sites[i].Ext = p.proxyValue(repo.Sites[i].Ext)
+		}
+	}
+	if p.fields["lives.url"] {
+		for i := range repo.Lives {
+			repo.Lives[i].URL = p.proxy(repo.Lives[i].URL)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package mixer
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wayjam/tvbox-mixproxy/config"
+	"github.com/wayjam/tvbox-mixproxy/pkg/resproxy"
+)
+
+func TestMixRepo_ResProxy(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"main": {
+				config: config.Source{Name: "main", URL: "https://example.com/tv/config.json", Type: config.SourceTypeSingle},
+				data: []byte(`{
+					"wallpaper": "https://example.com/bg.png",
+					"logo": "https://example.com/logo.png",
+					"sites": [
+						{"key": "site1", "type": 3, "api": "csp_One", "ext": {"url": "https://example.com/tv/ext.json", "list": ["https://example.com/a.txt", "text"]}},
+						{"key": "site2", "type": 3, "api": "csp_Two", "ext": "http://box:8080/v1/res?u=x"}
+					],
+					"lives": [{"name": "live1", "url": "https://example.com/live.m3u", "epg": "https://epg.example.com/?ch={name}"}]
+				}`),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		ExternalURL: "http://box:8080",
+		SingleRepoOpt: config.SingleRepoOpt{
+			Wallpaper: config.MixOpt{SourceName: "main"},
+			Logo:      config.MixOpt{SourceName: "main"},
+			Sites:     config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "main"}},
+			Lives:     config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "main"}},
+		},
+		ResProxy: config.ResProxyOpt{Secret: "secret", Fields: []string{"sites.ext", "lives.url"}},
+	}
+	cfg.Fixture()
+	proxied := func(uri string) string {
+		return resproxy.URL("http://box:8080", "secret", uri)
+	}
+
+	// 未启用时保持原地址
+	result, err := MixRepo(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/live.m3u", result.Lives[0].URL)
+
+	cfg.ResProxy.Enable = true
+	result, err = MixRepo(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/bg.png", result.Wallpaper)
+	assert.Equal(t, "https://example.com/logo.png", result.Logo)
+	assert.Equal(t, map[string]any{
+		"url":  proxied("https://example.com/tv/ext.json"),
+		"list": []any{proxied("https://example.com/a.txt"), "text"},
+	}, result.Sites[0].Ext)
+	assert.Equal(t, "http://box:8080/v1/res?u=x", result.Sites[1].Ext)
+	assert.Equal(t, proxied("https://example.com/live.m3u"), result.Lives[0].URL)
+	assert.Equal(t, "https://epg.example.com/?ch={name}", result.Lives[0].EPG)
+}
+
+func TestMixRepo_ResProxyWithURLRewrites(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"main": {
+				config: config.Source{Name: "main", URL: "https://example.com/tv/config.json", Type: config.SourceTypeSingle},
+				data:   []byte(`{"lives": [{"name": "live1", "url": "https://raw.githubusercontent.com/a/b/live.m3u"}]}`),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		ExternalURL: "http://box:8080",
+		SingleRepoOpt: config.SingleRepoOpt{
+			Lives: config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "main"}},
+		},
+		// 规则同样匹配代理地址中 u 参数的上游地址, 签名后再改写会使签名失效
+		URLRewrites: []config.URLRewrite{{Pattern: `raw\.githubusercontent\.com`, Replacement: "mirror.example.com"}},
+		ResProxy:    config.ResProxyOpt{Enable: true, Secret: "secret", Fields: []string{"lives.url"}},
+	}
+	cfg.Fixture()
+
+	result, err := MixRepo(cfg, mockSourcer)
+	assert.NoError(t, err)
+	proxied, err := url.Parse(result.Lives[0].URL)
+	assert.NoError(t, err)
+	assert.Equal(t, resproxy.Path, proxied.Path)
+	assert.Equal(t, "https://mirror.example.com/a/b/live.m3u", proxied.Query().Get("u"))
+	assert.True(t, resproxy.Verify("secret", proxied.Query().Get("u"), proxied.Query().Get("s")))
+}
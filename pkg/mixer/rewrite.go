@@ -0,0 +1,133 @@
+package mixer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/wayjam/tvbox-mixproxy/config"
+)
+
+// urlRewriter 将静态目录中的本地文件改为 /static 下的地址, 再按 url_rewrites 配置依次改写混合结果中的地址.
+// 其余 file:// 地址客户端无法访问, 且会暴露服务器上的路径, 记录为告警
+type urlRewriter struct {
+	static *staticDir // 未配置 static_dir 时为 nil
+	rules  []urlRewriteRule
+	local  []string // 结果中剩余的本地文件地址
+}
+
+type urlRewriteRule struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+func newURLRewriter(cfg *config.Config) (*urlRewriter, error) {
+	rewriter := &urlRewriter{rules: make([]urlRewriteRule, 0, len(cfg.URLRewrites))}
+	for i, rewrite := range cfg.URLRewrites {
+		re, err := regexp.Compile(rewrite.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern of url_rewrites[%d]: %w", i, err)
+		}
+		rewriter.rules = append(rewriter.rules, urlRewriteRule{re: re, replacement: rewrite.Replacement})
+	}
+
+	if cfg.StaticDir != "" {
+		static, err := newStaticDir(cfg.StaticDir, getExternalURL(cfg))
+		if err != nil {
+			return nil, err
+		}
+		rewriter.static = static
+	}
+	return rewriter, nil
+}
+
+// rewrite 依次应用所有规则
+func (r *urlRewriter) rewrite(s string) string {
+	if s == "" {
+		return s
+	}
+	if r.static != nil {
+		s = r.static.url(s)
+	}
+	r.checkLocal(s)
+	for _, rule := range r.rules {
+		s = rule.re.ReplaceAllString(s, rule.replacement)
+	}
+	return s
+}
+
+// checkLocal 记录未能改为对外地址的本地文件地址
+func (r *urlRewriter) checkLocal(s string) {
+	if strings.HasPrefix(s, "file://") {
+		r.local = append(r.local, s)
+	}
+}
+
+// report 将结果中剩余的本地文件地址记录为告警, 这些文件需要放入 static_dir 才能被客户端访问
+func (r *urlRewriter) report(report *MixReport) {
+	for _, s := range r.local {
+		report.LocalFiles = append(report.LocalFiles, s)
+		report.Warnings = append(report.Warnings, LocalFileWarning(s))
+	}
+}
+
+// rewriteValue 改写 ext 等任意 JSON 值中的所有字符串, 递归处理对象与数组
+func (r *urlRewriter) rewriteValue(v any) any {
+	switch v := v.(type) {
+	case string:
+		return r.rewrite(v)
+	case map[string]any:
+		for key, value := range v {
+			v[key] = r.rewriteValue(value)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = r.rewriteValue(value)
+		}
+	}
+	return v
+}
+
+// repo 改写单仓配置中所有包含地址的字段
+func (r *urlRewriter) repo(repo *config.RepoConfig) {
+	repo.Spider = r.rewrite(repo.Spider)
+	repo.Wallpaper = r.rewrite(repo.Wallpaper)
+	repo.Logo = r.rewrite(repo.Logo)
+	for i := range repo.Sites {
+		site := &repo.Sites[i]
+		site.API = r.rewrite(site.API)
+		site.Jar = r.rewrite(site.Jar)
+		site.Ext = r.rewriteValue(site.Ext)
+	}
+	for i := range repo.Lives {
+		live := &repo.Lives[i]
+		live.URL = r.rewrite(live.URL)
+		live.EPG = r.rewrite(live.EPG)
+		live.Logo = r.rewrite(live.Logo)
+	}
+	for i := range repo.Parses {
+		parse := &repo.Parses[i]
+		parse.URL = r.rewrite(parse.URL)
+		parse.Ext = r.rewriteValue(parse.Ext)
+	}
+	for i := range repo.DOH {
+		repo.DOH[i].URL = r.rewrite(repo.DOH[i].URL)
+	}
+	// 规则的 script 可能是 js 代码, 仅将静态目录中的脚本改为对外地址
+	for i := range repo.Rules {
+		for j, script := range repo.Rules[i].Script {
+			if r.static != nil {
+				script = r.static.url(script)
+			}
+			r.checkLocal(script)
+			repo.Rules[i].Script[j] = script
+		}
+	}
+}
+
+// multiRepo 改写多仓配置中的仓库地址
+func (r *urlRewriter) multiRepo(multiRepo *config.MultiRepoConfig) {
+	for i := range multiRepo.Repos {
+		multiRepo.Repos[i].URL = r.rewrite(multiRepo.Repos[i].URL)
+	}
+}
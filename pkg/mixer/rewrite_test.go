@@ -0,0 +1,83 @@
+package mixer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wayjam/tvbox-mixproxy/config"
+)
+
+func TestMixRepo_URLRewrites(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"main": {
+				config: config.Source{Name: "main", Type: config.SourceTypeSingle},
+				data: []byte(`{
+					"spider": "https://raw.githubusercontent.com/a/b/main/spider.jar;md5;abc",
+					"wallpaper": "https://raw.githubusercontent.com/a/b/main/bg.png",
+					"sites": [{
+						"key": "site1", "type": 3, "api": "https://raw.githubusercontent.com/a/b/main/api.js",
+						"jar": "https://raw.githubusercontent.com/a/b/main/site.jar",
+						"ext": {"url": "https://raw.githubusercontent.com/a/b/main/ext.json", "list": ["https://raw.githubusercontent.com/x", 1], "n": 2}
+					}, {
+						"key": "site2", "type": 3, "api": "csp_Two", "ext": "https://raw.githubusercontent.com/a/b/main/two.json"
+					}],
+					"lives": [{"name": "live1", "url": "https://raw.githubusercontent.com/a/b/main/live.m3u", "epg": "https://raw.githubusercontent.com/epg", "logo": "https://raw.githubusercontent.com/logo"}],
+					"parses": [{"name": "parse1", "url": "https://raw.githubusercontent.com/parse", "ext": {"flag": ["qq"]}}],
+					"doh": [{"name": "doh1", "url": "https://dns.example.com/dns-query"}]
+				}`),
+			},
+			"multi": {
+				config: config.Source{Name: "multi", Type: config.SourceTypeMulti},
+				data:   []byte(`{"urls": [{"name": "repo1", "url": "https://raw.githubusercontent.com/a/b/main/repo.json"}]}`),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		SingleRepoOpt: config.SingleRepoOpt{
+			Spider:    config.MixOpt{SourceName: "main"},
+			Wallpaper: config.MixOpt{SourceName: "main"},
+			Sites:     config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "main"}},
+			Lives:     config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "main"}},
+			Parses:    config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "main"}},
+			DOH:       config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "main"}},
+		},
+		MultiRepoOpt: config.MultiRepoOpt{
+			Repos: []config.ArrayMixOpt{{MixOpt: config.MixOpt{SourceName: "multi"}}},
+		},
+		URLRewrites: []config.URLRewrite{
+			{Pattern: `^https://raw\.githubusercontent\.com/([^/]+)/([^/]+)/`, Replacement: "https://mirror.example.com/$1/$2@"},
+			{Pattern: `^https://raw\.githubusercontent\.com/`, Replacement: "https://mirror.example.com/"},
+			{Pattern: `dns\.example\.com`, Replacement: "doh.example.com"},
+		},
+	}
+	cfg.Fixture()
+
+	result, err := MixRepo(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://mirror.example.com/a/b@main/spider.jar;md5;abc", result.Spider)
+	assert.Equal(t, "https://mirror.example.com/a/b@main/bg.png", result.Wallpaper)
+	assert.Equal(t, "https://mirror.example.com/a/b@main/api.js", result.Sites[0].API)
+	assert.Equal(t, "https://mirror.example.com/a/b@main/site.jar", result.Sites[0].Jar)
+	assert.Equal(t, map[string]any{
+		"url":  "https://mirror.example.com/a/b@main/ext.json",
+		"list": []any{"https://mirror.example.com/x", float64(1)},
+		"n":    float64(2),
+	}, result.Sites[0].Ext)
+	assert.Equal(t, "csp_Two", result.Sites[1].API)
+	assert.Equal(t, "https://mirror.example.com/a/b@main/two.json", result.Sites[1].Ext)
+	assert.Equal(t, "https://mirror.example.com/a/b@main/live.m3u", result.Lives[0].URL)
+	assert.Equal(t, "https://mirror.example.com/epg", result.Lives[0].EPG)
+	assert.Equal(t, "https://mirror.example.com/logo", result.Lives[0].Logo)
+	assert.Equal(t, "https://mirror.example.com/parse", result.Parses[0].URL)
+	assert.Equal(t, "https://doh.example.com/dns-query", result.DOH[0].URL)
+
+	multiRepo, err := MixMultiRepo(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://mirror.example.com/a/b@main/repo.json", multiRepo.Repos[0].URL)
+
+	cfg.URLRewrites = []config.URLRewrite{{Pattern: "("}}
+	_, err = MixRepo(cfg, mockSourcer)
+	assert.Error(t, err)
+}
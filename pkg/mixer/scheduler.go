@@ -0,0 +1,142 @@
+package mixer
+
+import (
+	"container/heap"
+	"context"
+	"math/rand"
+	"time"
+)
+
+const (
+	// DefaultRefreshInterval 为未配置 interval 与 cron, 上游也未返回 max-age 时的刷新间隔
+	DefaultRefreshInterval = 10 * time.Minute
+	// MaxBackoff 为连续失败后的最长退避时间
+	MaxBackoff = 10 * time.Minute
+)
+
+// backoffDuration 返回连续失败 errorCount 次后的退避时长: 2^errorCount 秒, 最长 MaxBackoff,
+// 并增加最多 20% 的随机抖动, 避免多个源同时重试
+func backoffDuration(errorCount int) time.Duration {
+	d := MaxBackoff
+	if errorCount < 20 {
+		if backoff := time.Duration(1<<errorCount) * time.Second; backoff < MaxBackoff {
+			d = backoff
+		}
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/5+1))
+}
+
+// scheduleItem 为刷新队列中的一项
+type scheduleItem struct {
+	name  string
+	at    time.Time
+	index int
+}
+
+// scheduleQueue 为按刷新时间排序的最小堆, 实现 heap.Interface
+type scheduleQueue []*scheduleItem
+
+func (q scheduleQueue) Len() int           { return len(q) }
+func (q scheduleQueue) Less(i, j int) bool { return q[i].at.Before(q[j].at) }
+
+func (q scheduleQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *scheduleQueue) Push(x any) {
+	item := x.(*scheduleItem)
+	item.index = len(*q)
+	*q = append(*q, item)
+}
+
+func (q *scheduleQueue) Pop() any {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return item
+}
+
+// scheduleLocked 按源的 nextRun 更新刷新队列, 禁用或从未获取过的源不参与调度, 调用方需持有锁
+func (sm *SourceManager) scheduleLocked(name string, source *sourceEntry) {
+	if sm.sources[name] != source {
+		// 源已在配置更新时被替换
+		return
+	}
+	if source.disabled || source.nextRun.IsZero() {
+		sm.unscheduleLocked(name)
+		return
+	}
+
+	if item, ok := sm.scheduled[name]; ok {
+		item.at = source.nextRun
+		heap.Fix(&sm.queue, item.index)
+	} else {
+		item := &scheduleItem{name: name, at: source.nextRun}
+		heap.Push(&sm.queue, item)
+		sm.scheduled[name] = item
+	}
+
+	// 唤醒调度循环重新计算等待时间
+	select {
+	case sm.wake <- struct{}{}:
+	default:
+	}
+}
+
+// unscheduleLocked 将源从刷新队列中移除, 调用方需持有锁
+func (sm *SourceManager) unscheduleLocked(name string) {
+	if item, ok := sm.scheduled[name]; ok {
+		heap.Remove(&sm.queue, item.index)
+		delete(sm.scheduled, name)
+	}
+}
+
+// run 按刷新时间依次刷新到期的源, 直到 ctx 被取消
+func (sm *SourceManager) run(ctx context.Context) {
+	defer close(sm.stopped)
+
+	for {
+		sm.mu.Lock()
+		now := time.Now()
+		var due []string
+		for len(sm.queue) > 0 && !sm.queue[0].at.After(now) {
+			item := heap.Pop(&sm.queue).(*scheduleItem)
+			delete(sm.scheduled, item.name)
+			due = append(due, item.name)
+		}
+		wait := time.Hour
+		if len(sm.queue) > 0 {
+			wait = sm.queue[0].at.Sub(now)
+		}
+		sm.mu.Unlock()
+
+		for _, name := range due {
+			go sm.refreshScheduled(name) // 异步刷新，避免阻塞
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-sm.wake:
+			timer.Stop()
+		}
+	}
+}
+
+// refreshScheduled 刷新到期的源, 无论是否实际刷新都将其重新加入队列
+func (sm *SourceManager) refreshScheduled(name string) {
+	sm.refreshSource(name, false)
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if source, ok := sm.sources[name]; ok {
+		sm.scheduleLocked(name, source)
+	}
+}
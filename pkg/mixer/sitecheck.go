@@ -0,0 +1,84 @@
+package mixer
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v3/log"
+
+	"github.com/wayjam/tvbox-mixproxy/config"
+	"github.com/wayjam/tvbox-mixproxy/pkg/jarinspect"
+)
+
+// SiteIssue 描述 csp 站点在其 spider jar 中找不到对应类等问题
+type SiteIssue struct {
+	Key    string `json:"key"`
+	API    string `json:"api"`
+	Jar    string `json:"jar,omitempty"` // 检查的 spider 地址
+	Reason string `json:"reason"`
+}
+
+func (i SiteIssue) String() string {
+	return fmt.Sprintf("site %s (%s): %s", i.Key, i.API, i.Reason)
+}
+
+// checkSite 检查 csp 站点的 spider 类是否存在于 spider 对应的 jar 中, 确认不存在时返回问题, source 为 spider 所在的源.
+// fetch 为 false 时只使用已缓存的 jar, 避免检查等待下载.
+// sourcer 不支持缓存 jar, spider 不是可下载的地址, jar 未缓存, 或 jar 无法下载与解析 (如加密的 jar) 时无法检查, 返回 nil
+func checkSite(site config.Site, spider string, source *Source, sourcer Sourcer, fetch bool) *SiteIssue {
+	jarSourcer, ok := sourcer.(JarSourcer)
+	if !ok || !isCSPSite(site) {
+		return nil
+	}
+
+	if spider == "" {
+		return &SiteIssue{Key: site.Key, API: site.API, Reason: "no spider jar"}
+	}
+
+	var jar *SpiderJar
+	if fetch {
+		jar, _ = jarSourcer.SpiderJar(spider, source)
+	} else {
+		jar = jarSourcer.CachedSpiderJar(spider, source)
+	}
+	if jar == nil {
+		return nil
+	}
+	inspected, err := jar.Inspect()
+	if err != nil {
+		log.Debugf("inspecting spider jar %s: %v", jar.URL, err)
+		return nil
+	}
+
+	if !inspected.HasSpider(site.API) {
+		return &SiteIssue{
+			Key:    site.Key,
+			API:    site.API,
+			Jar:    jar.URL,
+			Reason: fmt.Sprintf("class %s not found in spider jar", jarinspect.SpiderClass(site.API)),
+		}
+	}
+	return nil
+}
+
+// checkSites 检查单仓源中的 csp 站点, 站点未指定 jar 时使用该源的 spider
+func checkSites(source *Source, sourcer JarSourcer, fetch bool) []SiteIssue {
+	spider := sourceSpider(source)
+
+	sites, err := decodeArrayField[config.Site](source, config.ArrayMixOpt{MixOpt: config.MixOpt{Field: "sites"}})
+	if err != nil {
+		return []SiteIssue{{Reason: fmt.Sprintf("parsing sites: %v", err)}}
+	}
+
+	var issues []SiteIssue
+	for _, site := range sites {
+		site = processSiteFields(site, source)
+		jar := site.Jar
+		if jar == "" {
+			jar = spider
+		}
+		if issue := checkSite(site, jar, source, sourcer, fetch); issue != nil {
+			issues = append(issues, *issue)
+		}
+	}
+	return issues
+}
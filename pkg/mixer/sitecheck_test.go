@@ -0,0 +1,117 @@
+package mixer
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wayjam/tvbox-mixproxy/config"
+)
+
+// spiderJar 生成包含 com.github.catvod.spider 下指定类的 jar
+func spiderJar(t *testing.T, classes ...string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, class := range classes {
+		_, err := w.Create("com/github/catvod/spider/" + class + ".class")
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestCheckSites(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/main.json":
+			w.Write([]byte(`{"spider":"./main.jar","sites":[
+				{"key":"foo","type":3,"api":"csp_Foo"},
+				{"key":"gone","type":3,"api":"csp_Gone"},
+				{"key":"own","type":3,"api":"csp_Own","jar":"./own.jar"},
+				{"key":"cms","type":1,"api":"csp_NotCSP"}
+			]}`))
+		case "/other.json":
+			w.Write([]byte(`{"spider":"./other.jar","sites":[
+				{"key":"bar","type":3,"api":"csp_Bar"},
+				{"key":"foo2","type":3,"api":"csp_Foo"}
+			]}`))
+		case "/main.jar":
+			w.Write(spiderJar(t, "Foo"))
+		case "/own.jar":
+			w.Write(spiderJar(t, "Own"))
+		case "/other.jar":
+			w.Write(spiderJar(t, "Bar"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	sm := NewSourceManager([]config.Source{
+		{Name: "main", URL: upstream.URL + "/main.json", Type: config.SourceTypeSingle},
+		{Name: "other", URL: upstream.URL + "/other.json", Type: config.SourceTypeSingle},
+	})
+	defer sm.Close()
+
+	// 未获取过数据时不检查
+	issues, err := sm.CheckSites("main", true)
+	assert.NoError(t, err)
+	assert.Empty(t, issues)
+
+	_, err = sm.GetSource("main")
+	assert.NoError(t, err)
+	issues, err = sm.CheckSites("main", true)
+	assert.NoError(t, err)
+	if assert.Len(t, issues, 1) {
+		assert.Equal(t, "gone", issues[0].Key)
+		assert.Equal(t, upstream.URL+"/main.jar", issues[0].Jar)
+		assert.Contains(t, issues[0].Reason, "com.github.catvod.spider.Gone")
+	}
+
+	_, err = sm.CheckSites("unknown", true)
+	assert.ErrorIs(t, err, ErrSourceNotFound)
+
+	// 只检查已缓存的 jar 时不等待下载, jar 在后台缓存后才能检查
+	_, err = sm.GetSource("other")
+	assert.NoError(t, err)
+	issues, err = sm.CheckSites("other", false)
+	assert.NoError(t, err)
+	assert.Empty(t, issues)
+	assert.Eventually(t, func() bool {
+		issues, _ = sm.CheckSites("other", false)
+		return len(issues) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// 混合时站点使用其所在源的 spider, 找不到类的站点记录告警
+	cfg := &config.Config{
+		SingleRepoOpt: config.SingleRepoOpt{
+			Spider: config.MixOpt{SourceName: "main", Field: "spider"},
+			Sites:  config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "other", Field: "sites"}},
+		},
+	}
+	result, report, err := MixRepoWithReport(cfg, sm)
+	assert.NoError(t, err)
+	assert.Len(t, result.Sites, 2)
+	if assert.Len(t, report.SiteIssues, 1) {
+		assert.Equal(t, "foo2", report.SiteIssues[0].Key)
+	}
+	assert.Len(t, report.Warnings, 1)
+
+	// 配置丢弃时移除找不到类的站点
+	cfg.SiteCheck.DropMissing = true
+	result, _, err = MixRepoWithReport(cfg, sm)
+	assert.NoError(t, err)
+	if assert.Len(t, result.Sites, 1) {
+		assert.Equal(t, "bar", result.Sites[0].Key)
+	}
+
+	cfg.SingleRepoOpt.Sites.SourceName = "main"
+	result, report, err = MixRepoWithReport(cfg, sm)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo", "own", "cms"}, []string{result.Sites[0].Key, result.Sites[1].Key, result.Sites[2].Key})
+	assert.Len(t, report.SiteIssues, 1)
+}
@@ -1,12 +1,21 @@
 package mixer
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"math"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/gofiber/fiber/v3/log"
+
 	"github.com/wayjam/tvbox-mixproxy/config"
+	"github.com/wayjam/tvbox-mixproxy/pkg/cron"
 )
 
 type Sourcer interface {
@@ -25,19 +34,99 @@ type Sourcer interface {
 // 	_ Sourcer = &Source{}
 // )
 
+// ErrSourceNotFound 表示请求的源未在配置中定义
+var ErrSourceNotFound = errors.New("source not found")
+
+// ErrSourceDisabled 表示请求的源已被禁用
+var ErrSourceDisabled = errors.New("source disabled")
+
+// ErrClosed 表示 SourceManager 已关闭, 不再从上游获取数据
+var ErrClosed = errors.New("source manager closed")
+
 type SourceManager struct {
-	sources map[string]*Source
-	mu      sync.RWMutex
-	ticker  *time.Ticker
-	done    chan bool
+	sources     map[string]*sourceEntry
+	mu          sync.RWMutex
+	flight      flightGroup // 合并同一源的并发刷新
+	cacheDir    string      // 历史版本的保存目录, 为空时仅保存在内存中
+	historySize int
+	generation  atomic.Uint64 // 源数据、启用状态或 spider jar 每次变化时递增
+	jars        *jarCache
+
+	// 刷新调度, queue 与 scheduled 由 mu 保护
+	ctx       context.Context
+	queue     scheduleQueue
+	scheduled map[string]*scheduleItem
+	wake      chan struct{}
+	cancel    context.CancelFunc
+	stopped   chan struct{}
+
+	closed   bool           // 由 mu 保护, 关闭后不再开始新的获取
+	inflight sync.WaitGroup // 正在进行的获取, 关闭时等待其写入缓存
 }
 
+// SourceManagerOption 为创建 SourceManager 时的可选配置
+type SourceManagerOption func(*SourceManager)
+
+// WithCacheDir 将源的历史版本保存在 dir 中, 重启后可继续使用缓存数据与固定版本
+func WithCacheDir(dir string) SourceManagerOption {
+	return func(sm *SourceManager) {
+		sm.cacheDir = dir
+	}
+}
+
+// WithContext 使用 ctx 控制后台刷新, ctx 取消后停止刷新, 与调用 Close 效果相同
+func WithContext(ctx context.Context) SourceManagerOption {
+	return func(sm *SourceManager) {
+		sm.ctx = ctx
+	}
+}
+
+// WithHistorySize 设置每个源保留的历史版本数量, 不大于 0 时使用默认值
+func WithHistorySize(size int) SourceManagerOption {
+	return func(sm *SourceManager) {
+		sm.historySize = size
+	}
+}
+
+// Source 为源数据的不可变快照, 刷新时整体替换而不会修改, 可以在多个 goroutine 中安全使用
 type Source struct {
-	config     config.Source
-	lastUpdate time.Time
-	data       []byte // Change this to []byte
-	lastError  time.Time
-	errorCount int
+	config  config.Source
+	data    []byte
+	hash    string // data 的 sha256
+	baseURL string // 获取数据时重定向后的最终地址, 用于解析相对地址
+}
+
+// sourceEntry 保存源的运行时状态, 除 snapshot 外的字段均由 SourceManager.mu 保护
+type sourceEntry struct {
+	config       config.Source
+	snapshot     atomic.Pointer[Source] // 当前使用的数据, 未获取过数据时为 nil
+	disabled     bool                   // 是否禁用, 初始值来自配置, 可在运行时切换
+	schedule     *cron.Schedule         // 配置了 cron 时的刷新计划
+	lastUpdate   time.Time
+	nextRun      time.Time     // 下一次计划刷新时间, 失败后为退避结束时间, 从未获取过时为零值
+	maxAge       time.Duration // 上游最近一次返回的 Cache-Control: max-age
+	baseURL      string        // 上游最近一次返回数据的最终地址, 未获取过时为空
+	previous     []byte        // 上一个不同版本的数据, 用于比较变化
+	lastError    time.Time
+	lastErrorMsg string
+	errorCount   int
+	history      *history // 最近的不同版本, 固定版本时 snapshot 为固定的版本
+}
+
+// SourceStatus 描述源的当前状态, 用于状态接口展示
+type SourceStatus struct {
+	Name        string            `json:"name"`
+	Type        config.SourceType `json:"type"`
+	URL         string            `json:"url"`
+	Disabled    bool              `json:"disabled"`
+	LastUpdate  *time.Time        `json:"last_update,omitempty"`   // 最近一次成功更新时间
+	LastError   string            `json:"last_error,omitempty"`    // 最近一次错误信息
+	LastErrorAt *time.Time        `json:"last_error_at,omitempty"` // 最近一次错误时间
+	ErrorCount  int               `json:"error_count"`             // 连续失败次数
+	NextRefresh time.Time         `json:"next_refresh"`            // 下一次计划刷新时间
+	Size        int               `json:"size"`                    // 缓存数据大小, 单位为字节
+	Hash        string            `json:"hash,omitempty"`          // 缓存数据的 sha256
+	Pinned      bool              `json:"pinned"`                  // 是否固定使用 hash 对应的版本
 }
 
 func (s *Source) Data() []byte {
@@ -52,110 +141,684 @@ func (s *Source) URL() string {
 	return s.config.URL
 }
 
+// BaseURL 返回解析源中相对地址时使用的基准地址, 即重定向后的最终地址, 未知时为配置的地址
+func (s *Source) BaseURL() string {
+	if s.baseURL != "" {
+		return s.baseURL
+	}
+	return s.config.URL
+}
+
 func (s *Source) Name() string {
 	return s.config.Name
 }
 
+// isLocal 判断源本身是否为本地文件, 只有本地源中的 file:// 地址可以读取
+func (s *Source) isLocal() bool {
+	return strings.HasPrefix(s.config.URL, "file://")
+}
+
 func (s *Source) GetSource(name string) ([]byte, error) {
 	return s.data, nil
 }
 
-func NewSourceManager(sources []config.Source) *SourceManager {
+func (e *sourceEntry) data() []byte {
+	if snapshot := e.snapshot.Load(); snapshot != nil {
+		return snapshot.data
+	}
+	return nil
+}
+
+func (e *sourceEntry) hash() string {
+	if snapshot := e.snapshot.Load(); snapshot != nil {
+		return snapshot.hash
+	}
+	return ""
+}
+
+// status 返回源的当前状态, 调用方需持有锁
+func (s *sourceEntry) status() SourceStatus {
+	status := SourceStatus{
+		Name:       s.config.Name,
+		Type:       s.config.Type,
+		URL:        s.config.URL,
+		Disabled:   s.disabled,
+		LastError:  s.lastErrorMsg,
+		ErrorCount: s.errorCount,
+		Size:       len(s.data()),
+		Hash:       s.hash(),
+		Pinned:     s.history != nil && s.history.Pinned != "",
+	}
+
+	if !s.lastUpdate.IsZero() {
+		lastUpdate := s.lastUpdate
+		status.LastUpdate = &lastUpdate
+	}
+	if !s.lastError.IsZero() {
+		lastError := s.lastError
+		status.LastErrorAt = &lastError
+	}
+
+	// 未获取过数据的源会在下一次访问时立即刷新
+	status.NextRefresh = s.nextRun
+	if s.nextRun.IsZero() {
+		status.NextRefresh = time.Now()
+	}
+
+	return status
+}
+
+// nextRefresh 返回数据在 from 时刻更新后的下一次刷新时间,
+// 依次使用 cron、interval、上游的 max-age 与默认刷新间隔
+func (e *sourceEntry) nextRefresh(from time.Time) time.Time {
+	if e.schedule != nil {
+		if next := e.schedule.Next(from); !next.IsZero() {
+			return next
+		}
+	}
+
+	interval := time.Duration(e.config.Interval) * time.Second
+	if interval <= 0 {
+		interval = e.maxAge
+	}
+	if interval <= 0 {
+		interval = DefaultRefreshInterval
+	}
+	return from.Add(interval)
+}
+
+func NewSourceManager(sources []config.Source, opts ...SourceManagerOption) *SourceManager {
 	sm := &SourceManager{
-		sources: make(map[string]*Source),
-		ticker:  time.NewTicker(1 * time.Minute), // 每分钟检查一次
-		done:    make(chan bool),
+		sources:   make(map[string]*sourceEntry),
+		ctx:       context.Background(),
+		scheduled: make(map[string]*scheduleItem),
+		wake:      make(chan struct{}, 1),
+		stopped:   make(chan struct{}),
 	}
+	for _, opt := range opts {
+		opt(sm)
+	}
+	sm.jars = newJarCache(sm.cacheDir, func() { sm.generation.Add(1) })
 
 	for _, s := range sources {
-		sm.sources[s.Name] = &Source{
-			config: s,
-		}
+		source := sm.newSource(s)
+		sm.sources[s.Name] = source
+		sm.scheduleLocked(s.Name, source)
 	}
 
-	go sm.refreshLoop()
+	var ctx context.Context
+	ctx, sm.cancel = context.WithCancel(sm.ctx)
+	go sm.run(ctx)
 
 	return sm
 }
 
-func (sm *SourceManager) refreshLoop() {
-	for {
-		select {
-		case <-sm.ticker.C:
-			sm.refreshExpiredSources()
-		case <-sm.done:
-			sm.ticker.Stop()
-			return
+// newSource 创建源并加载其历史版本, 缓存目录中的数据作为过期数据使用,
+// 首次访问时仍会从上游刷新
+func (sm *SourceManager) newSource(cfg config.Source) *sourceEntry {
+	source := &sourceEntry{
+		config:   cfg,
+		disabled: cfg.Disabled,
+	}
+	source.parseCron()
+
+	h, err := loadHistory(sm.cacheDir, cfg.Name, cfg.URL, sm.historySize)
+	if err != nil {
+		log.Warnf("loading history of source %s: %v", cfg.Name, err)
+	}
+	source.history = h
+
+	hash := h.Pinned
+	if hash == "" {
+		if latest, ok := h.latest(); ok {
+			hash = latest.Hash
 		}
 	}
+	if hash != "" {
+		data, err := h.get(hash)
+		if err != nil {
+			log.Warnf("loading cached data of source %s: %v", cfg.Name, err)
+			return source
+		}
+		source.snapshot.Store(&Source{config: cfg, data: data, hash: hash})
+		// 缓存的数据视为已过期, 尽快从上游刷新
+		source.nextRun = time.Now()
+	}
+
+	return source
 }
 
-func (sm *SourceManager) refreshExpiredSources() {
-	sm.mu.RLock()
-	defer sm.mu.RUnlock()
+// parseCron 解析配置中的 cron 表达式, 无效的表达式会被忽略
+func (e *sourceEntry) parseCron() {
+	e.schedule = nil
+	if e.config.Cron == "" {
+		return
+	}
+	schedule, err := cron.Parse(e.config.Cron)
+	if err != nil {
+		log.Warnf("ignoring invalid cron of source %s: %v", e.config.Name, err)
+		return
+	}
+	e.schedule = schedule
+}
 
-	for name, source := range sm.sources {
-		if time.Since(source.lastUpdate) > time.Duration(source.config.Interval)*time.Second {
-			go sm.refreshSource(name) // 异步刷新，避免阻塞
+// Update 按新的源配置增量更新, 未变化的源保留缓存数据与运行时状态;
+// 仅 interval 或 disabled 变化的源同样保留缓存数据
+func (sm *SourceManager) Update(sources []config.Source) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	updated := make(map[string]*sourceEntry, len(sources))
+	for _, s := range sources {
+		old, ok := sm.sources[s.Name]
+		switch {
+		case ok && old.config == s:
+			updated[s.Name] = old
+		case ok && old.config.URL == s.URL && old.config.Type == s.Type:
+			if old.config.Disabled != s.Disabled {
+				old.disabled = s.Disabled
+			}
+			old.config = s
+			old.parseCron()
+			if snapshot := old.snapshot.Load(); snapshot != nil {
+				old.snapshot.Store(&Source{config: s, data: snapshot.data, hash: snapshot.hash, baseURL: snapshot.baseURL})
+				if old.errorCount == 0 {
+					old.nextRun = old.nextRefresh(old.lastUpdate)
+				}
+			}
+			updated[s.Name] = old
+		default:
+			updated[s.Name] = sm.newSource(s)
+		}
+	}
+
+	for name := range sm.sources {
+		if _, ok := updated[name]; !ok {
+			sm.unscheduleLocked(name)
 		}
 	}
+
+	sm.sources = updated
+	for name, source := range updated {
+		sm.scheduleLocked(name, source)
+	}
+	sm.generation.Add(1)
 }
 
+// GetSource 返回源当前的数据快照, 数据已过期时先从上游刷新
 func (sm *SourceManager) GetSource(name string) (*Source, error) {
 	sm.mu.RLock()
 	source, ok := sm.sources[name]
+	var disabled, expired bool
+	if ok {
+		disabled = source.disabled
+		expired = source.expired()
+	}
 	sm.mu.RUnlock()
 
 	if !ok {
-		return nil, fmt.Errorf("source not found: %s", name)
+		return nil, fmt.Errorf("%w: %s", ErrSourceNotFound, name)
+	}
+	if disabled {
+		return nil, fmt.Errorf("%w: %s", ErrSourceDisabled, name)
 	}
 
-	if time.Since(source.lastUpdate) > time.Duration(source.config.Interval)*time.Second || source.data == nil {
-		if err := sm.refreshSource(name); err != nil {
-			return nil, err
+	if expired {
+		if err := sm.refreshSource(name, false); err != nil {
+			if source.snapshot.Load() == nil {
+				return nil, err
+			}
+			// 刷新失败但已有缓存数据时，继续提供旧数据
+			log.Warnf("refreshing source %s failed, serving stale data: %v", name, err)
 		}
 	}
 
-	return source, nil
+	// 固定的版本在缓存目录中缺失时没有可用的数据
+	snapshot := source.snapshot.Load()
+	if snapshot == nil {
+		return nil, fmt.Errorf("source %s has no data available", name)
+	}
+	return snapshot, nil
 }
 
-func (sm *SourceManager) refreshSource(name string) error {
-	sm.mu.Lock()
+// expired 返回源数据是否需要刷新, 调用方需持有锁
+func (e *sourceEntry) expired() bool {
+	return e.snapshot.Load() == nil || !time.Now().Before(e.nextRun)
+}
+
+// refreshSource 从上游重新加载源数据, force 为 true 时忽略退避时间, 已禁用的源不会刷新.
+// 同一源的并发刷新会合并为一次请求
+func (sm *SourceManager) refreshSource(name string, force bool) error {
+	sm.mu.RLock()
 	source, ok := sm.sources[name]
 	if !ok {
-		sm.mu.Unlock()
-		return fmt.Errorf("source not found: %s", name)
+		sm.mu.RUnlock()
+		return fmt.Errorf("%w: %s", ErrSourceNotFound, name)
+	}
+	if source.disabled {
+		sm.mu.RUnlock()
+		return fmt.Errorf("%w: %s", ErrSourceDisabled, name)
 	}
 
 	// 指数退避
-	if !source.lastError.IsZero() {
-		backoff := time.Duration(math.Pow(2, float64(source.errorCount))) * time.Second
-		if time.Since(source.lastError) < backoff {
-			sm.mu.Unlock()
-			return fmt.Errorf("too many errors, try again later")
+	if !force && source.errorCount > 0 && time.Now().Before(source.nextRun) {
+		sm.mu.RUnlock()
+		return fmt.Errorf("too many errors, try again later")
+	}
+	sm.mu.RUnlock()
+
+	return sm.flight.do(name, func() error {
+		sm.mu.RLock()
+		if sm.closed {
+			sm.mu.RUnlock()
+			return ErrClosed
+		}
+		url := source.config.URL
+		// 等待锁期间其他调用可能已经完成刷新
+		fresh := !force && !source.expired()
+		if !fresh {
+			sm.inflight.Add(1)
+		}
+		sm.mu.RUnlock()
+		if fresh {
+			return nil
+		}
+		defer sm.inflight.Done()
+
+		data, meta, err := config.LoadDataWithMeta(url)
+
+		sm.mu.Lock()
+		defer sm.mu.Unlock()
+
+		now := time.Now()
+		if err != nil {
+			source.lastError = now
+			source.lastErrorMsg = err.Error()
+			source.errorCount++
+			source.nextRun = now.Add(backoffDuration(source.errorCount))
+			sm.scheduleLocked(name, source)
+			return err
+		}
+
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		if err := source.history.add(hash, data, now); err != nil {
+			log.Warnf("saving history of source %s: %v", name, err)
+		}
+
+		source.baseURL = meta.URL
+		// 固定版本时仅记录新版本, 继续使用固定的版本
+		if source.history.Pinned == "" {
+			sm.setData(source, hash, data)
 		}
+		source.lastUpdate = now
+		source.lastError = time.Time{}
+		source.lastErrorMsg = ""
+		source.errorCount = 0
+		source.maxAge = meta.MaxAge
+		source.nextRun = source.nextRefresh(now)
+		sm.scheduleLocked(name, source)
+		return nil
+	})
+}
+
+// setData 以新的快照替换源当前使用的数据, 数据或基准地址变化时递增 generation, 调用方需持有锁
+func (sm *SourceManager) setData(source *sourceEntry, hash string, data []byte) {
+	current := source.snapshot.Load()
+	if current != nil && current.hash == hash && current.baseURL == source.baseURL {
+		return
+	}
+	if current != nil && current.hash != hash {
+		source.previous = current.data
 	}
+	source.snapshot.Store(&Source{config: source.config, data: data, hash: hash, baseURL: source.baseURL})
+	sm.generation.Add(1)
+}
 
-	sm.mu.Unlock()
+// Generation 返回源数据的版本号, 任意源的数据或启用状态变化后版本号都会改变,
+// 可用于判断基于源数据生成的结果是否需要重新生成
+func (sm *SourceManager) Generation() uint64 {
+	return sm.generation.Load()
+}
+
+// DefaultPrefetchConcurrency 为预取时的默认并发数
+const DefaultPrefetchConcurrency = 8
+
+// Prefetch 以最多 concurrency 个并发获取所有未禁用的源, 返回每个源的错误.
+// ctx 结束时立即返回, 未完成的源的错误为 ctx 的错误, 这些源仍会在后台按并发限制继续获取
+func (sm *SourceManager) Prefetch(ctx context.Context, concurrency int) map[string]error {
+	if concurrency <= 0 {
+		concurrency = DefaultPrefetchConcurrency
+	}
+
+	sm.mu.RLock()
+	var names []string
+	for name, source := range sm.sources {
+		if !source.disabled {
+			names = append(names, name)
+		}
+	}
+	sm.mu.RUnlock()
+
+	type result struct {
+		name string
+		err  error
+	}
+	var (
+		results = make(chan result, len(names))
+		sem     = make(chan struct{}, concurrency)
+	)
+	for _, name := range names {
+		go func(name string) {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results <- result{name, sm.refreshSource(name, false)}
+		}(name)
+	}
+
+	errs := make(map[string]error)
+	pending := make(map[string]bool, len(names))
+	for _, name := range names {
+		pending[name] = true
+	}
+	for len(pending) > 0 {
+		select {
+		case r := <-results:
+			delete(pending, r.name)
+			if r.err != nil {
+				errs[r.name] = r.err
+			}
+		case <-ctx.Done():
+			for name := range pending {
+				errs[name] = ctx.Err()
+			}
+			return errs
+		}
+	}
+
+	return errs
+}
+
+// SpiderJar 返回 spider 地址对应的缓存 jar, 地址可带有 ;md5; 校验信息.
+// source 为 spider 所在的源, 为 nil 时地址来自代理服务的配置;
+// 不是 http(s):// 地址, 或 file:// 地址来自网络源时返回 nil
+func (sm *SourceManager) SpiderJar(spider string, source *Source) (*SpiderJar, error) {
+	return sm.jars.get(spider, source == nil || source.isLocal())
+}
+
+// CachedSpiderJar 与 SpiderJar 相同, 但不会等待下载: 只返回已缓存的 jar, 未缓存时在后台下载并返回 nil
+func (sm *SourceManager) CachedSpiderJar(spider string, source *Source) *SpiderJar {
+	return sm.jars.cached(spider, source == nil || source.isLocal())
+}
+
+// Refresh 立即刷新指定源, 忽略更新间隔与退避时间, 已禁用的源返回 ErrSourceDisabled
+func (sm *SourceManager) Refresh(name string) error {
+	return sm.refreshSource(name, true)
+}
+
+// RefreshAll 并发地立即刷新所有未禁用的源, 返回每个失败源的错误
+func (sm *SourceManager) RefreshAll() map[string]error {
+	sm.mu.RLock()
+	var names []string
+	for name, source := range sm.sources {
+		if !source.disabled {
+			names = append(names, name)
+		}
+	}
+	sm.mu.RUnlock()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs = make(map[string]error)
+	)
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if err := sm.refreshSource(name, true); err != nil {
+				mu.Lock()
+				errs[name] = err
+				mu.Unlock()
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// SetDisabled 在运行时启用或禁用指定源, 禁用的源不会被刷新, 也不会参与混合
+func (sm *SourceManager) SetDisabled(name string, disabled bool) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	source, ok := sm.sources[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSourceNotFound, name)
+	}
+	if source.disabled != disabled {
+		source.disabled = disabled
+		sm.scheduleLocked(name, source)
+		sm.generation.Add(1)
+	}
+
+	return nil
+}
+
+// Status 返回所有源的状态, 按名称排序
+func (sm *SourceManager) Status() []SourceStatus {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	statuses := make([]SourceStatus, 0, len(sm.sources))
+	for _, source := range sm.sources {
+		statuses = append(statuses, source.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	return statuses
+}
+
+// SourceStatus 返回指定源的状态
+func (sm *SourceManager) SourceStatus(name string) (SourceStatus, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	source, ok := sm.sources[name]
+	if !ok {
+		return SourceStatus{}, fmt.Errorf("%w: %s", ErrSourceNotFound, name)
+	}
+
+	return source.status(), nil
+}
+
+// PreviousData 返回指定源上一个不同版本的数据, 源数据未变化过时为 nil
+func (sm *SourceManager) PreviousData(name string) ([]byte, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	source, ok := sm.sources[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSourceNotFound, name)
+	}
+
+	return source.previous, nil
+}
+
+// CachedData 返回指定源当前缓存的原始数据, 不会触发刷新
+func (sm *SourceManager) CachedData(name string) ([]byte, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	source, ok := sm.sources[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSourceNotFound, name)
+	}
+
+	return source.data(), nil
+}
+
+// CheckSites 检查单仓源当前数据中的 csp 站点, 返回 spider jar 中找不到对应类的站点;
+// 源未获取过数据或不是单仓源时返回 nil. fetch 为 false 时只检查已缓存的 jar,
+// 未缓存的 jar 在后台下载, 之后的检查才会包含使用这些 jar 的站点
+func (sm *SourceManager) CheckSites(name string, fetch bool) ([]SiteIssue, error) {
+	sm.mu.RLock()
+	source, ok := sm.sources[name]
+	sm.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSourceNotFound, name)
+	}
+
+	snapshot := source.snapshot.Load()
+	if snapshot == nil || snapshot.Type() != config.SourceTypeSingle {
+		return nil, nil
+	}
+	return checkSites(snapshot, sm, fetch), nil
+}
+
+// Versions 返回指定源保留的历史版本, 按获取时间从新到旧排列
+func (sm *SourceManager) Versions(name string) ([]SourceVersion, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
 
-	data, err := config.LoadData(source.config.URL)
+	source, ok := sm.sources[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSourceNotFound, name)
+	}
+
+	return source.history.versions(), nil
+}
 
+// VersionData 返回指定源某个历史版本的数据, version 可以是完整的 hash 或其前缀
+func (sm *SourceManager) VersionData(name, version string) ([]byte, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	source, ok := sm.sources[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSourceNotFound, name)
+	}
+
+	hash, err := source.history.resolve(version)
+	if err != nil {
+		return nil, err
+	}
+	return source.history.get(hash)
+}
+
+// Pin 将指定源固定为某个历史版本, version 可以是完整的 hash 或其前缀.
+// 固定后源仍会按计划刷新并记录新版本, 但混合时始终使用固定的版本, 直到调用 Unpin
+func (sm *SourceManager) Pin(name, version string) (SourceVersion, error) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
+	source, ok := sm.sources[name]
+	if !ok {
+		return SourceVersion{}, fmt.Errorf("%w: %s", ErrSourceNotFound, name)
+	}
+
+	hash, err := source.history.resolve(version)
+	if err != nil {
+		return SourceVersion{}, err
+	}
+
+	return sm.pin(source, hash)
+}
+
+// Rollback 将指定源固定为当前使用版本的上一个版本
+func (sm *SourceManager) Rollback(name string) (SourceVersion, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	source, ok := sm.sources[name]
+	if !ok {
+		return SourceVersion{}, fmt.Errorf("%w: %s", ErrSourceNotFound, name)
+	}
+
+	versions := source.history.Versions
+	for i, v := range versions {
+		if v.Hash == source.hash() && i+1 < len(versions) {
+			return sm.pin(source, versions[i+1].Hash)
+		}
+	}
+
+	return SourceVersion{}, fmt.Errorf("%w: no version older than %s", ErrVersionNotFound, source.hash())
+}
+
+// Unpin 取消固定版本, 恢复使用最新获取到的版本
+func (sm *SourceManager) Unpin(name string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	source, ok := sm.sources[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSourceNotFound, name)
+	}
+
+	if err := source.history.setPinned(""); err != nil {
+		return err
+	}
+
+	latest, ok := source.history.latest()
+	if !ok {
+		return nil
+	}
+	data, err := source.history.get(latest.Hash)
 	if err != nil {
-		source.lastError = time.Now()
-		source.errorCount++
 		return err
 	}
+	sm.setData(source, latest.Hash, data)
 
-	source.data = data
-	source.lastUpdate = time.Now()
-	source.lastError = time.Time{}
-	source.errorCount = 0
 	return nil
 }
 
+// pin 固定使用 hash 对应的版本, 调用方需持有锁
+func (sm *SourceManager) pin(source *sourceEntry, hash string) (SourceVersion, error) {
+	data, err := source.history.get(hash)
+	if err != nil {
+		return SourceVersion{}, err
+	}
+	if err := source.history.setPinned(hash); err != nil {
+		return SourceVersion{}, err
+	}
+	sm.setData(source, hash, data)
+
+	for _, v := range source.history.versions() {
+		if v.Hash == hash {
+			return v, nil
+		}
+	}
+	return SourceVersion{}, nil
+}
+
+// Close 停止后台刷新并等待调度循环退出, 可以重复调用
 func (sm *SourceManager) Close() {
-	sm.done <- true
+	sm.cancel()
+	<-sm.stopped
+}
+
+// Shutdown 停止后台刷新, 不再从上游获取数据, 并等待正在进行的获取完成及缓存写入磁盘.
+// ctx 结束时不再等待并返回错误
+func (sm *SourceManager) Shutdown(ctx context.Context) error {
+	sm.Close()
+
+	sm.mu.Lock()
+	sm.closed = true
+	sm.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		sm.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for source refreshes: %w", ctx.Err())
+	}
 }
@@ -1,9 +1,14 @@
 package mixer
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -18,6 +23,7 @@ func TestNewSourceManager(t *testing.T) {
 	}
 
 	sm := NewSourceManager(sources)
+	defer sm.Close()
 
 	assert.NotNil(t, sm)
 	assert.Len(t, sm.sources, 2)
@@ -41,6 +47,7 @@ func TestGetSource(t *testing.T) {
 	}
 
 	sm := NewSourceManager(sources)
+	defer sm.Close()
 
 	// First call should trigger a refresh
 	data, err := sm.GetSource("test")
@@ -65,9 +72,9 @@ func TestGetSource(t *testing.T) {
 }
 
 func TestRefreshSource(t *testing.T) {
-	callCount := 0
+	var callCount atomic.Int32
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		callCount++
+		callCount.Add(1)
 		config := config.RepoConfig{
 			Spider: "test_spider",
 			Sites:  []config.Site{{Key: "test_site", Name: "Test Site"}},
@@ -81,17 +88,626 @@ func TestRefreshSource(t *testing.T) {
 	}
 
 	sm := NewSourceManager(sources)
+	defer sm.Close()
 
 	// First call
 	_, err := sm.GetSource("test")
 	assert.NoError(t, err)
-	assert.Equal(t, 1, callCount)
+	assert.Equal(t, int32(1), callCount.Load())
 
-	// Wait for the interval to pass
-	time.Sleep(2 * time.Second)
+	// 后台按各源的间隔刷新, 支持小于 1 分钟的间隔
+	assert.Eventually(t, func() bool { return callCount.Load() == 2 }, 5*time.Second, 10*time.Millisecond)
 
-	// Second call should trigger a refresh
+	// 数据未过期时直接返回缓存
 	_, err = sm.GetSource("test")
 	assert.NoError(t, err)
-	assert.Equal(t, 2, callCount)
+	assert.Equal(t, int32(2), callCount.Load())
+}
+
+func TestGetSource_ServeStale(t *testing.T) {
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(config.RepoConfig{Spider: "test_spider"})
+	}))
+	defer server.Close()
+
+	sources := []config.Source{
+		{Name: "test", URL: server.URL, Type: config.SourceTypeSingle, Interval: 1},
+	}
+
+	sm := NewSourceManager(sources)
+	defer sm.Close()
+
+	_, err := sm.GetSource("test")
+	assert.NoError(t, err)
+
+	// 上游失败后仍返回上一次成功的数据
+	failing.Store(true)
+	assert.Eventually(t, func() bool {
+		status, _ := sm.SourceStatus("test")
+		return status.ErrorCount > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	source, err := sm.GetSource("test")
+	assert.NoError(t, err)
+	assert.Contains(t, string(source.Data()), "test_spider")
+	status, _ := sm.SourceStatus("test")
+	assert.Equal(t, 1, status.ErrorCount)
+}
+
+func TestSourceManagerStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"spider":"test_spider"}`))
+	}))
+	defer server.Close()
+
+	sources := []config.Source{
+		{Name: "b", URL: server.URL, Type: config.SourceTypeSingle, Interval: 60},
+		{Name: "a", URL: "http://127.0.0.1:0/unreachable", Type: config.SourceTypeMulti, Interval: 60},
+	}
+
+	sm := NewSourceManager(sources)
+	defer sm.Close()
+
+	_, err := sm.GetSource("b")
+	assert.NoError(t, err)
+	_, err = sm.GetSource("a")
+	assert.Error(t, err)
+
+	statuses := sm.Status()
+	assert.Len(t, statuses, 2)
+
+	assert.Equal(t, "a", statuses[0].Name)
+	assert.Equal(t, config.SourceTypeMulti, statuses[0].Type)
+	assert.Nil(t, statuses[0].LastUpdate)
+	assert.NotEmpty(t, statuses[0].LastError)
+	assert.Equal(t, 1, statuses[0].ErrorCount)
+	assert.Zero(t, statuses[0].Size)
+
+	assert.Equal(t, "b", statuses[1].Name)
+	assert.NotNil(t, statuses[1].LastUpdate)
+	assert.Empty(t, statuses[1].LastError)
+	assert.Equal(t, len(`{"spider":"test_spider"}`), statuses[1].Size)
+	assert.Len(t, statuses[1].Hash, 64)
+	assert.WithinDuration(t, statuses[1].LastUpdate.Add(60*time.Second), statuses[1].NextRefresh, time.Second)
+
+	data, err := sm.CachedData("b")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"spider":"test_spider"}`, string(data))
+
+	_, err = sm.CachedData("non_existent")
+	assert.ErrorIs(t, err, ErrSourceNotFound)
+}
+
+func TestSourceManagerRefreshAndDisable(t *testing.T) {
+	var callCount atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callCount.Add(1)
+		w.Write([]byte(`{"sites":[{"key":"site1","name":"Site 1"}]}`))
+	}))
+	defer server.Close()
+
+	sources := []config.Source{
+		{Name: "test", URL: server.URL, Type: config.SourceTypeSingle, Interval: 3600},
+		{Name: "off", URL: server.URL, Type: config.SourceTypeSingle, Interval: 3600, Disabled: true},
+	}
+
+	sm := NewSourceManager(sources)
+	defer sm.Close()
+
+	_, err := sm.GetSource("test")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), callCount.Load())
+
+	// 强制刷新会忽略更新间隔
+	assert.NoError(t, sm.Refresh("test"))
+	assert.Equal(t, int32(2), callCount.Load())
+
+	// 批量刷新跳过已禁用的源
+	assert.Empty(t, sm.RefreshAll())
+	assert.Equal(t, int32(3), callCount.Load())
+
+	// 强制刷新已禁用的源同样不会请求上游
+	assert.ErrorIs(t, sm.Refresh("off"), ErrSourceDisabled)
+	assert.Equal(t, int32(3), callCount.Load())
+
+	_, err = sm.GetSource("off")
+	assert.ErrorIs(t, err, ErrSourceDisabled)
+
+	cfg := &config.Config{
+		SingleRepoOpt: config.SingleRepoOpt{
+			Sites: config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "test", Field: "sites"}},
+		},
+	}
+
+	result, err := MixRepo(cfg, sm)
+	assert.NoError(t, err)
+	assert.Len(t, result.Sites, 1)
+
+	// 运行时禁用后混合结果立即生效
+	assert.NoError(t, sm.SetDisabled("test", true))
+	result, err = MixRepo(cfg, sm)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Sites)
+
+	assert.NoError(t, sm.SetDisabled("test", false))
+	result, err = MixRepo(cfg, sm)
+	assert.NoError(t, err)
+	assert.Len(t, result.Sites, 1)
+
+	assert.ErrorIs(t, sm.SetDisabled("non_existent", true), ErrSourceNotFound)
+}
+
+func TestSourceManagerUpdate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"spider":"test_spider"}`))
+	}))
+	defer server.Close()
+
+	sm := NewSourceManager([]config.Source{
+		{Name: "keep", URL: server.URL + "/keep", Type: config.SourceTypeSingle, Interval: 60},
+		{Name: "retune", URL: server.URL + "/retune", Type: config.SourceTypeSingle, Interval: 60},
+		{Name: "move", URL: server.URL + "/move", Type: config.SourceTypeSingle, Interval: 60},
+		{Name: "drop", URL: server.URL + "/drop", Type: config.SourceTypeSingle, Interval: 60},
+	})
+	defer sm.Close()
+	for _, name := range []string{"keep", "retune", "move", "drop"} {
+		_, err := sm.GetSource(name)
+		assert.NoError(t, err)
+	}
+	keep := sm.sources["keep"]
+
+	sm.Update([]config.Source{
+		{Name: "keep", URL: server.URL + "/keep", Type: config.SourceTypeSingle, Interval: 60},
+		{Name: "retune", URL: server.URL + "/retune", Type: config.SourceTypeSingle, Interval: 120, Disabled: true},
+		{Name: "move", URL: server.URL + "/moved", Type: config.SourceTypeSingle, Interval: 60},
+		{Name: "new", URL: server.URL + "/new", Type: config.SourceTypeSingle, Interval: 60},
+	})
+
+	assert.Len(t, sm.sources, 4)
+	assert.Same(t, keep, sm.sources["keep"])
+	assert.NotNil(t, sm.sources["retune"].data())
+	assert.Equal(t, 120, sm.sources["retune"].config.Interval)
+	assert.True(t, sm.sources["retune"].disabled)
+	assert.Nil(t, sm.sources["move"].data())
+	assert.Nil(t, sm.sources["new"].data())
+	assert.NotContains(t, sm.sources, "drop")
+}
+
+func TestSourceManagerPreviousData(t *testing.T) {
+	var version atomic.Int32
+	version.Store(1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fmt.Sprintf(`{"spider":"spider_v%d"}`, version.Load())))
+	}))
+	defer server.Close()
+
+	sm := NewSourceManager([]config.Source{
+		{Name: "test", URL: server.URL, Type: config.SourceTypeSingle, Interval: 3600},
+	})
+	defer sm.Close()
+
+	assert.NoError(t, sm.Refresh("test"))
+	previous, err := sm.PreviousData("test")
+	assert.NoError(t, err)
+	assert.Nil(t, previous)
+
+	// 数据未变化时不更新上一个版本
+	assert.NoError(t, sm.Refresh("test"))
+	previous, _ = sm.PreviousData("test")
+	assert.Nil(t, previous)
+
+	version.Store(2)
+	assert.NoError(t, sm.Refresh("test"))
+	previous, _ = sm.PreviousData("test")
+	assert.Equal(t, `{"spider":"spider_v1"}`, string(previous))
+}
+
+func TestSourceManagerPinAndRollback(t *testing.T) {
+	var version atomic.Int32
+	version.Store(1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fmt.Sprintf(`{"spider":"spider_v%d"}`, version.Load())))
+	}))
+	defer server.Close()
+
+	sources := []config.Source{
+		{Name: "test", URL: server.URL, Type: config.SourceTypeSingle, Interval: 3600},
+	}
+	cacheDir := t.TempDir()
+	sm := NewSourceManager(sources, WithCacheDir(cacheDir), WithHistorySize(2))
+	defer sm.Close()
+
+	for v := int32(1); v <= 3; v++ {
+		version.Store(v)
+		assert.NoError(t, sm.Refresh("test"))
+	}
+
+	// 仅保留最近 2 个版本
+	versions, err := sm.Versions("test")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 2)
+
+	// 回滚到上一个版本, 之后的刷新不影响固定的版本
+	_, err = sm.Rollback("test")
+	assert.NoError(t, err)
+	data, _ := sm.CachedData("test")
+	assert.Equal(t, `{"spider":"spider_v2"}`, string(data))
+
+	version.Store(4)
+	assert.NoError(t, sm.Refresh("test"))
+	data, _ = sm.CachedData("test")
+	assert.Equal(t, `{"spider":"spider_v2"}`, string(data))
+
+	status, _ := sm.SourceStatus("test")
+	assert.True(t, status.Pinned)
+
+	_, err = sm.Rollback("test")
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+
+	// 重启后从缓存目录恢复固定的版本
+	restarted := NewSourceManager(sources, WithCacheDir(cacheDir), WithHistorySize(2))
+	defer restarted.Close()
+	data, _ = restarted.CachedData("test")
+	assert.Equal(t, `{"spider":"spider_v2"}`, string(data))
+
+	versions, _ = restarted.Versions("test")
+	assert.Len(t, versions, 2)
+	assert.True(t, versions[1].Pinned)
+
+	// 按前缀固定, 取消固定后恢复使用最新版本
+	_, err = restarted.Pin("test", versions[0].Hash[:8])
+	assert.NoError(t, err)
+	data, _ = restarted.CachedData("test")
+	assert.Equal(t, `{"spider":"spider_v4"}`, string(data))
+
+	_, err = restarted.Pin("test", "not-exist")
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+
+	assert.NoError(t, restarted.Unpin("test"))
+	status, _ = restarted.SourceStatus("test")
+	assert.False(t, status.Pinned)
+	assert.Equal(t, versions[0].Hash, status.Hash)
+}
+
+func TestSourceManagerPinWithHistorySizeOne(t *testing.T) {
+	var version atomic.Int32
+	version.Store(1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fmt.Sprintf(`{"spider":"spider_v%d"}`, version.Load())))
+	}))
+	defer server.Close()
+
+	sources := []config.Source{
+		{Name: "test", URL: server.URL, Type: config.SourceTypeSingle, Interval: 3600},
+	}
+	cacheDir := t.TempDir()
+	sm := NewSourceManager(sources, WithCacheDir(cacheDir), WithHistorySize(1))
+	defer sm.Close()
+
+	assert.NoError(t, sm.Refresh("test"))
+	versions, _ := sm.Versions("test")
+	pinned := versions[0].Hash
+	_, err := sm.Pin("test", pinned)
+	assert.NoError(t, err)
+
+	// 新获取的版本与固定的版本都不会被淘汰
+	version.Store(2)
+	assert.NoError(t, sm.Refresh("test"))
+	versions, _ = sm.Versions("test")
+	if assert.Len(t, versions, 2) {
+		data, err := sm.VersionData("test", versions[0].Hash)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"spider":"spider_v2"}`, string(data))
+		assert.Equal(t, pinned, versions[1].Hash)
+	}
+	data, _ := sm.CachedData("test")
+	assert.Equal(t, `{"spider":"spider_v1"}`, string(data))
+
+	// 固定的版本在缓存目录中缺失时返回错误
+	assert.NoError(t, os.Remove(filepath.Join(cacheDir, "sources", "test", pinned+".json")))
+	restarted := NewSourceManager(sources, WithCacheDir(cacheDir), WithHistorySize(1))
+	defer restarted.Close()
+	_, err = restarted.GetSource("test")
+	assert.Error(t, err)
+}
+
+func TestSourceManagerUnchangedData(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"spider":"spider_v1"}`))
+	}))
+	defer server.Close()
+
+	sources := []config.Source{
+		{Name: "test", URL: server.URL, Type: config.SourceTypeSingle, Interval: 3600},
+	}
+	cacheDir := t.TempDir()
+	sm := NewSourceManager(sources, WithCacheDir(cacheDir))
+	defer sm.Close()
+
+	assert.NoError(t, sm.Refresh("test"))
+	versions, _ := sm.Versions("test")
+	file := filepath.Join(cacheDir, "sources", "test", versions[0].Hash+".json")
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	assert.NoError(t, os.Chtimes(file, past, past))
+
+	// 数据未变化时不重写版本数据, 只更新获取时间
+	assert.NoError(t, sm.Refresh("test"))
+	info, err := os.Stat(file)
+	assert.NoError(t, err)
+	assert.True(t, info.ModTime().Equal(past))
+	updated, _ := sm.Versions("test")
+	assert.Len(t, updated, 1)
+	assert.True(t, updated[0].FetchedAt.After(versions[0].FetchedAt))
+}
+
+func TestSourceManagerURLChanged(t *testing.T) {
+	oldServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"spider":"spider_old"}`))
+	}))
+	defer oldServer.Close()
+	newServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"spider":"spider_new"}`))
+	}))
+	defer newServer.Close()
+
+	cacheDir := t.TempDir()
+	sources := []config.Source{
+		{Name: "test", URL: oldServer.URL, Type: config.SourceTypeSingle, Interval: 3600},
+	}
+	sm := NewSourceManager(sources, WithCacheDir(cacheDir))
+	defer sm.Close()
+
+	assert.NoError(t, sm.Refresh("test"))
+	versions, _ := sm.Versions("test")
+	_, err := sm.Pin("test", versions[0].Hash)
+	assert.NoError(t, err)
+
+	// 地址变更后丢弃旧地址的历史与固定的版本
+	sources[0].URL = newServer.URL
+	sm.Update(sources)
+	versions, _ = sm.Versions("test")
+	assert.Empty(t, versions)
+	source, err := sm.GetSource("test")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"spider":"spider_new"}`, string(source.Data()))
+
+	// 重启时使用不同的地址同样不会加载旧的历史
+	sources[0].URL = oldServer.URL
+	restarted := NewSourceManager(sources, WithCacheDir(cacheDir))
+	defer restarted.Close()
+	data, err := restarted.CachedData("test")
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+	versions, _ = restarted.Versions("test")
+	assert.Empty(t, versions)
+}
+
+func TestSourceManagerGeneration(t *testing.T) {
+	var version atomic.Int32
+	version.Store(1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fmt.Sprintf(`{"spider":"spider_v%d"}`, version.Load())))
+	}))
+	defer server.Close()
+
+	sm := NewSourceManager([]config.Source{
+		{Name: "test", URL: server.URL, Type: config.SourceTypeSingle, Interval: 3600},
+	})
+	defer sm.Close()
+
+	assert.NoError(t, sm.Refresh("test"))
+	gen := sm.Generation()
+
+	// 数据未变化时版本号不变
+	assert.NoError(t, sm.Refresh("test"))
+	assert.Equal(t, gen, sm.Generation())
+
+	version.Store(2)
+	assert.NoError(t, sm.Refresh("test"))
+	assert.NotEqual(t, gen, sm.Generation())
+
+	gen = sm.Generation()
+	assert.NoError(t, sm.SetDisabled("test", true))
+	assert.NotEqual(t, gen, sm.Generation())
+}
+
+func TestSourceManagerRedirectBaseURL(t *testing.T) {
+	var target atomic.Value
+	target.Store("/v1/config.json")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest" {
+			http.Redirect(w, r, target.Load().(string), http.StatusFound)
+			return
+		}
+		w.Write([]byte(`{"spider":"./spider.jar"}`))
+	}))
+	defer server.Close()
+
+	sm := NewSourceManager([]config.Source{
+		{Name: "test", URL: server.URL + "/latest", Type: config.SourceTypeSingle, Interval: 3600},
+	})
+	defer sm.Close()
+
+	// 相对地址按重定向后的地址解析
+	source, err := sm.GetSource("test")
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/v1/config.json", source.BaseURL())
+	assert.Equal(t, server.URL+"/v1/spider.jar", sourceSpider(source))
+
+	// 数据未变化但重定向目标变化时同样更新
+	gen := sm.Generation()
+	target.Store("/v2/config.json")
+	assert.NoError(t, sm.Refresh("test"))
+	assert.NotEqual(t, gen, sm.Generation())
+	source, err = sm.GetSource("test")
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/v2/spider.jar", sourceSpider(source))
+}
+
+func TestSourceManagerSchedule(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/max-age" {
+			w.Header().Set("Cache-Control", "public, max-age=120")
+		}
+		w.Write([]byte(`{"spider":"test_spider"}`))
+	}))
+	defer server.Close()
+
+	sm := NewSourceManager([]config.Source{
+		{Name: "default", URL: server.URL, Type: config.SourceTypeSingle},
+		{Name: "max-age", URL: server.URL + "/max-age", Type: config.SourceTypeSingle},
+		{Name: "interval", URL: server.URL + "/max-age", Type: config.SourceTypeSingle, Interval: 30},
+		{Name: "cron", URL: server.URL, Type: config.SourceTypeSingle, Cron: "0 4 * * *"},
+	})
+	defer sm.Close()
+
+	assert.Empty(t, sm.RefreshAll())
+
+	next := func(name string) time.Duration {
+		status, err := sm.SourceStatus(name)
+		assert.NoError(t, err)
+		return status.NextRefresh.Sub(*status.LastUpdate)
+	}
+	assert.Equal(t, DefaultRefreshInterval, next("default"))
+	assert.Equal(t, 120*time.Second, next("max-age"))
+	assert.Equal(t, 30*time.Second, next("interval"))
+
+	status, _ := sm.SourceStatus("cron")
+	assert.Equal(t, 4, status.NextRefresh.Hour())
+	assert.Zero(t, status.NextRefresh.Minute())
+	assert.WithinDuration(t, *status.LastUpdate, status.NextRefresh, 24*time.Hour)
+}
+
+func TestBackoffDuration(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		d := backoffDuration(1)
+		assert.GreaterOrEqual(t, d, 2*time.Second)
+		assert.LessOrEqual(t, d, 2400*time.Millisecond)
+
+		// 退避时间有上限
+		d = backoffDuration(100)
+		assert.GreaterOrEqual(t, d, MaxBackoff)
+		assert.LessOrEqual(t, d, MaxBackoff*6/5)
+	}
+}
+
+func TestSourceManagerStopWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sm := NewSourceManager(nil, WithContext(ctx))
+
+	cancel()
+	select {
+	case <-sm.stopped:
+	case <-time.After(time.Second):
+		t.Fatal("scheduler did not stop after context was cancelled")
+	}
+
+	// 已停止后 Close 不会阻塞
+	sm.Close()
+}
+
+func TestSourceManagerPrefetch(t *testing.T) {
+	var active, maxActive atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			m := maxActive.Load()
+			if n <= m || maxActive.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"spider":"test_spider"}`))
+	}))
+	defer server.Close()
+
+	var sources []config.Source
+	for i := 0; i < 6; i++ {
+		sources = append(sources, config.Source{Name: fmt.Sprintf("s%d", i), URL: fmt.Sprintf("%s/s%d", server.URL, i), Interval: 3600})
+	}
+	sources = append(sources,
+		config.Source{Name: "fail", URL: server.URL + "/fail"},
+		config.Source{Name: "off", URL: server.URL, Disabled: true},
+	)
+	sm := NewSourceManager(sources)
+	defer sm.Close()
+
+	start := time.Now()
+	errs := sm.Prefetch(context.Background(), 3)
+	assert.Less(t, time.Since(start), 600*time.Millisecond)
+	assert.LessOrEqual(t, maxActive.Load(), int32(3))
+	assert.Len(t, errs, 1)
+	assert.Contains(t, errs, "fail")
+
+	data, _ := sm.CachedData("s5")
+	assert.NotNil(t, data)
+	data, _ = sm.CachedData("off")
+	assert.Nil(t, data)
+}
+
+func TestSourceManagerPrefetchDeadline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(`{"spider":"test_spider"}`))
+	}))
+	defer server.Close()
+
+	sm := NewSourceManager([]config.Source{
+		{Name: "slow", URL: server.URL, Interval: 3600},
+	})
+	defer sm.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	errs := sm.Prefetch(ctx, 1)
+	assert.ErrorIs(t, errs["slow"], context.DeadlineExceeded)
+
+	// 超时后仍在后台继续获取
+	assert.Eventually(t, func() bool {
+		data, _ := sm.CachedData("slow")
+		return data != nil
+	}, time.Second, 20*time.Millisecond)
+}
+
+func TestSourceManagerShutdown(t *testing.T) {
+	started := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(`{"spider":"test_spider"}`))
+	}))
+	defer server.Close()
+
+	cacheDir := t.TempDir()
+	sources := []config.Source{{Name: "test", URL: server.URL, Interval: 3600}}
+	sm := NewSourceManager(sources, WithCacheDir(cacheDir))
+
+	refreshed := make(chan error, 1)
+	go func() {
+		refreshed <- sm.Refresh("test")
+	}()
+	<-started
+
+	// 等待正在进行的获取完成并写入缓存
+	assert.NoError(t, sm.Shutdown(context.Background()))
+	assert.NoError(t, <-refreshed)
+	assert.ErrorIs(t, sm.Refresh("test"), ErrClosed)
+
+	restarted := NewSourceManager(sources, WithCacheDir(cacheDir))
+	defer restarted.Close()
+	data, _ := restarted.CachedData("test")
+	assert.Equal(t, `{"spider":"test_spider"}`, string(data))
 }
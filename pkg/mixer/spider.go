@@ -0,0 +1,389 @@
+package mixer
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3/log"
+	"github.com/tidwall/gjson"
+
+	"github.com/wayjam/tvbox-mixproxy/config"
+	"github.com/wayjam/tvbox-mixproxy/pkg/jarinspect"
+)
+
+const (
+	// JarRevalidateInterval 为缓存的 spider jar 重新校验的间隔, 地址中声明的 md5 与缓存一致时不再校验
+	JarRevalidateInterval = 10 * time.Minute
+	// jarRetryInterval 为 jar 下载失败后再次尝试的最短间隔
+	jarRetryInterval = time.Minute
+	// jarUnusedTTL 为 jar 缓存的保留时间, 超过该时间未被使用的地址从缓存中移除
+	jarUnusedTTL = 24 * time.Hour
+
+	jarIndexFile = "index.json"
+)
+
+// JarSourcer 为能够缓存 spider jar 的 Sourcer, 混合时 spider 改为代理地址并使用缓存内容的 md5
+type JarSourcer interface {
+	Sourcer
+	SpiderJar(spider string, source *Source) (*SpiderJar, error)
+	// CachedSpiderJar 与 SpiderJar 相同, 但只返回已缓存的 jar, 未缓存时在后台下载并返回 nil
+	CachedSpiderJar(spider string, source *Source) *SpiderJar
+}
+
+// SpiderJar 为缓存的 spider jar, 按内容的 md5 保存
+type SpiderJar struct {
+	URL       string    `json:"url"` // 去除校验信息后的上游地址
+	MD5       string    `json:"md5"`
+	FetchedAt time.Time `json:"fetched_at"`
+	data      []byte
+
+	inspectOnce sync.Once
+	inspected   *jarinspect.Jar
+	inspectErr  error
+}
+
+func (j *SpiderJar) Data() []byte {
+	return j.data
+}
+
+// Inspect 列出 jar 中定义的类, 结果在首次调用后缓存
+func (j *SpiderJar) Inspect() (*jarinspect.Jar, error) {
+	j.inspectOnce.Do(func() {
+		j.inspected, j.inspectErr = jarinspect.Inspect(j.data)
+	})
+	return j.inspected, j.inspectErr
+}
+
+// jarEntry 为某个地址的缓存状态, 存入 jarCache 后不再修改
+type jarEntry struct {
+	jar       *SpiderJar // 最近一次成功下载的 jar, 从未成功时为 nil
+	err       error      // 最近一次下载的错误
+	checkedAt time.Time  // 最近一次下载的时间, 从磁盘加载时为零值
+	declared  string     // 最近一次下载时地址中声明的 md5
+}
+
+// jarCache 缓存 spider jar, dir 不为空时 jar 以 <md5>.jar 保存在 dir 中, 重启后仍然可用
+type jarCache struct {
+	dir     string
+	changed func() // 某个地址对应的 jar 内容变化时调用
+
+	mu      sync.Mutex
+	entries map[string]*jarEntry // 去除校验信息的地址 -> 缓存
+	used    map[string]time.Time // 地址最近一次被使用的时间, 用于移除不再使用的地址
+	flight  flightGroup          // 合并同一地址的并发下载
+}
+
+// newJarCache 创建 jar 缓存并加载 cacheDir 中已有的 jar, cacheDir 为空时仅保存在内存中
+func newJarCache(cacheDir string, changed func()) *jarCache {
+	c := &jarCache{
+		changed: changed,
+		entries: make(map[string]*jarEntry),
+		used:    make(map[string]time.Time),
+	}
+	if cacheDir == "" {
+		return c
+	}
+
+	c.dir = filepath.Join(cacheDir, "jars")
+	if err := c.load(); err != nil {
+		log.Warnf("loading spider jar cache: %v", err)
+	}
+	return c
+}
+
+// load 从缓存目录加载 jar 索引, 数据文件缺失或损坏的 jar 被忽略
+func (c *jarCache) load() error {
+	content, err := os.ReadFile(filepath.Join(c.dir, jarIndexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var jars []*SpiderJar
+	if err := json.Unmarshal(content, &jars); err != nil {
+		return fmt.Errorf("parsing %s: %w", jarIndexFile, err)
+	}
+	for _, jar := range jars {
+		data, err := os.ReadFile(c.jarPath(jar.MD5))
+		if err != nil || md5Hex(data) != jar.MD5 {
+			continue
+		}
+		jar.data = data
+		c.entries[jar.URL] = &jarEntry{jar: jar}
+		c.used[jar.URL] = time.Now()
+	}
+	return nil
+}
+
+// get 返回 spider 地址对应的 jar, 地址可带有 ;md5; 校验信息.
+// 声明的 md5 与缓存不同且未按该 md5 下载过时立即重新下载, 说明上游的 jar 已更新;
+// 其他情况下已缓存的 jar 过了校验间隔后在后台重新下载, 期间继续返回已缓存的版本.
+// 不是 http(s):// 地址, 或 allowFile 为 false 时的 file:// 地址返回 nil
+func (c *jarCache) get(spider string, allowFile bool) (*SpiderJar, error) {
+	uri, declared := splitSpider(spider)
+	if !isJarURL(uri) || !allowFile && strings.HasPrefix(uri, "file://") {
+		return nil, nil
+	}
+
+	c.mu.Lock()
+	entry := c.entries[uri]
+	c.used[uri] = time.Now()
+	c.mu.Unlock()
+
+	if entry != nil && entry.jar != nil {
+		if entry.jar.MD5 == declared {
+			return entry.jar, nil
+		}
+		if declared != "" && declared != entry.declared {
+			// 声明错误时同一个 md5 只重新下载一次, 下载失败时继续使用已缓存的版本
+			if jar, err := c.fetch(uri, declared); err == nil {
+				return jar, nil
+			}
+			return entry.jar, nil
+		}
+		if time.Since(entry.checkedAt) >= JarRevalidateInterval {
+			go c.fetch(uri, declared) // 后台重新校验, 避免阻塞请求
+		}
+		return entry.jar, nil
+	}
+	if entry != nil && time.Since(entry.checkedAt) < jarRetryInterval {
+		return nil, entry.err
+	}
+
+	return c.fetch(uri, declared)
+}
+
+// cached 与 get 相同, 但不会阻塞下载: 只返回已缓存的 jar, 未缓存时在后台下载并返回 nil
+func (c *jarCache) cached(spider string, allowFile bool) *SpiderJar {
+	uri, declared := splitSpider(spider)
+	if !isJarURL(uri) || !allowFile && strings.HasPrefix(uri, "file://") {
+		return nil
+	}
+
+	c.mu.Lock()
+	entry := c.entries[uri]
+	c.used[uri] = time.Now()
+	c.mu.Unlock()
+
+	if entry != nil && entry.jar != nil {
+		// 与 get 相同的条件下重新下载, 但在后台进行, 期间继续返回已缓存的版本
+		if entry.jar.MD5 != declared && (declared != "" && declared != entry.declared ||
+			time.Since(entry.checkedAt) >= JarRevalidateInterval) {
+			go c.fetch(uri, declared)
+		}
+		return entry.jar
+	}
+	if entry == nil || time.Since(entry.checkedAt) >= jarRetryInterval {
+		go c.fetch(uri, declared)
+	}
+	return nil
+}
+
+// fetch 下载 uri 对应的 jar 并更新缓存, declared 为地址中声明的 md5, 同一地址的并发下载只执行一次
+func (c *jarCache) fetch(uri, declared string) (*SpiderJar, error) {
+	err := c.flight.do(uri, func() error {
+		data, err := config.FetchData(uri)
+		now := time.Now()
+		if err != nil {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			// 下载失败时保留已缓存的 jar
+			failed := &jarEntry{err: err, checkedAt: now, declared: declared}
+			if old := c.entries[uri]; old != nil {
+				failed.jar = old.jar
+			}
+			c.entries[uri] = failed
+			c.used[uri] = now
+			return err
+		}
+
+		jar := &SpiderJar{URL: uri, MD5: md5Hex(data), FetchedAt: now, data: data}
+		if err := c.writeJar(jar); err != nil {
+			log.Warnf("saving spider jar %s: %v", uri, err)
+		}
+
+		c.mu.Lock()
+		old := c.entries[uri]
+		c.entries[uri] = &jarEntry{jar: jar, checkedAt: now, declared: declared}
+		c.used[uri] = now
+		changed := old == nil || old.jar == nil || old.jar.MD5 != jar.MD5
+		if changed && old != nil && old.jar != nil {
+			c.removeUnusedLocked(old.jar.MD5)
+		}
+		c.pruneLocked(now)
+		if err := c.saveLocked(); err != nil {
+			log.Warnf("saving spider jar index: %v", err)
+		}
+		c.mu.Unlock()
+
+		if changed && c.changed != nil {
+			c.changed()
+		}
+		return nil
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.entries[uri]
+	if err != nil {
+		return nil, fmt.Errorf("downloading spider jar %s: %w", uri, err)
+	}
+	return entry.jar, nil
+}
+
+// writeJar 将 jar 按 md5 保存到缓存目录, 内容相同的文件已存在时跳过
+func (c *jarCache) writeJar(jar *SpiderJar) error {
+	if c.dir == "" {
+		return nil
+	}
+	path := c.jarPath(jar.MD5)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := writeFileSync(tmp, jar.data); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// pruneLocked 移除超过 jarUnusedTTL 未被使用的地址及其 jar 文件, 调用方需持有锁
+func (c *jarCache) pruneLocked(now time.Time) {
+	var removed []string
+	for uri, entry := range c.entries {
+		if now.Sub(c.used[uri]) < jarUnusedTTL {
+			continue
+		}
+		delete(c.entries, uri)
+		delete(c.used, uri)
+		if entry.jar != nil {
+			removed = append(removed, entry.jar.MD5)
+		}
+	}
+	for _, md5 := range removed {
+		c.removeUnusedLocked(md5)
+	}
+}
+
+// removeUnusedLocked 删除不再被任何地址使用的 jar 文件, 调用方需持有锁
+func (c *jarCache) removeUnusedLocked(md5 string) {
+	if c.dir == "" {
+		return
+	}
+	for _, entry := range c.entries {
+		if entry.jar != nil && entry.jar.MD5 == md5 {
+			return
+		}
+	}
+	os.Remove(c.jarPath(md5))
+}
+
+// saveLocked 保存 jar 索引, 调用方需持有锁
+func (c *jarCache) saveLocked() error {
+	if c.dir == "" {
+		return nil
+	}
+
+	var jars []*SpiderJar
+	for _, entry := range c.entries {
+		if entry.jar != nil {
+			jars = append(jars, entry.jar)
+		}
+	}
+	content, err := json.MarshalIndent(jars, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(c.dir, jarIndexFile+".tmp")
+	if err := writeFileSync(tmp, content); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(c.dir, jarIndexFile))
+}
+
+func (c *jarCache) jarPath(md5 string) string {
+	return filepath.Join(c.dir, md5+".jar")
+}
+
+// splitSpider 将 spider 地址拆分为上游地址与其中声明的 md5, 格式为 url;md5;xxx
+func splitSpider(spider string) (uri, md5 string) {
+	parts := strings.Split(spider, ";")
+	if len(parts) >= 3 && parts[1] == "md5" {
+		md5 = strings.ToLower(parts[2])
+	}
+	return parts[0], md5
+}
+
+func isJarURL(uri string) bool {
+	return strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://") ||
+		strings.HasPrefix(uri, "file://")
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// proxySpider 在 sourcer 已缓存 jar 时将 source 中的 spider 改为 path 下的代理地址, md5 为缓存内容的实际值.
+// 混合不等待 jar 下载: 未缓存时在后台下载并保留原地址, 下载完成后 generation 变化, 之后的混合使用代理地址
+func proxySpider(cfg *config.Config, spider string, source *Source, path string, sourcer Sourcer) string {
+	jarSourcer, ok := sourcer.(JarSourcer)
+	if !ok {
+		return spider
+	}
+
+	jar := jarSourcer.CachedSpiderJar(spider, source)
+	if jar == nil {
+		return spider
+	}
+	return getExternalURL(cfg) + path + ";md5;" + jar.MD5
+}
+
+// sourceSpider 返回 source 自身 spider 的完整地址, 源中没有 spider 时返回空字符串
+func sourceSpider(source *Source) string {
+	return fullFillURL(gjson.GetBytes(source.Data(), "spider").String(), source)
+}
+
+// sourceSpiderPath 返回 source 的 spider 在代理服务中的路径
+func sourceSpiderPath(source *Source) string {
+	return "/v1/spider/" + url.PathEscape(source.Name())
+}
+
+// isCSPSite 判断站点是否由 spider jar 中的类实现
+func isCSPSite(site config.Site) bool {
+	return site.Type == 3 && strings.HasPrefix(site.API, "csp_")
+}
+
+// ResolveSpiderJar 返回 mixOpt 对应的缓存 jar; 字段或源被禁用、spider 不是可下载的地址,
+// 或 sourcer 不支持缓存 jar 时返回 nil
+func ResolveSpiderJar(mixOpt config.MixOpt, sourcer Sourcer) (*SpiderJar, error) {
+	jarSourcer, ok := sourcer.(JarSourcer)
+	if !ok {
+		return nil, nil
+	}
+
+	spider, source, err := resolveMixValue(mixOpt, sourcer)
+	if err != nil || spider == "" {
+		return nil, err
+	}
+	return jarSourcer.SpiderJar(spider, source)
+}
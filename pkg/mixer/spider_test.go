@@ -0,0 +1,286 @@
+package mixer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wayjam/tvbox-mixproxy/config"
+)
+
+func TestSplitSpider(t *testing.T) {
+	uri, md5 := splitSpider("https://example.com/spider.jar;md5;ABC")
+	assert.Equal(t, "https://example.com/spider.jar", uri)
+	assert.Equal(t, "abc", md5)
+
+	uri, md5 = splitSpider("https://example.com/spider.jar")
+	assert.Equal(t, "https://example.com/spider.jar", uri)
+	assert.Empty(t, md5)
+}
+
+func TestJarCache(t *testing.T) {
+	var (
+		content  atomic.Value
+		requests atomic.Int64
+	)
+	content.Store("jar v1")
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte(content.Load().(string)))
+	}))
+	defer upstream.Close()
+
+	dir := t.TempDir()
+	var changes atomic.Int64
+	c := newJarCache(dir, func() { changes.Add(1) })
+
+	jar, err := c.get(upstream.URL+"/spider.jar;md5;outdated", false)
+	assert.NoError(t, err)
+	assert.Equal(t, md5Hex([]byte("jar v1")), jar.MD5)
+	assert.Equal(t, "jar v1", string(jar.Data()))
+	assert.Equal(t, int64(1), changes.Load())
+
+	// 校验间隔内不重新下载
+	_, err = c.get(upstream.URL+"/spider.jar", false)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), requests.Load())
+
+	// 不可下载的地址不缓存
+	jar, err = c.get("spider_v1", false)
+	assert.NoError(t, err)
+	assert.Nil(t, jar)
+
+	// 内容变化后 md5 随之变化, 并通知调用方
+	content.Store("jar v2")
+	jar, err = c.fetch(upstream.URL+"/spider.jar", "")
+	assert.NoError(t, err)
+	assert.Equal(t, md5Hex([]byte("jar v2")), jar.MD5)
+	assert.Equal(t, int64(2), changes.Load())
+	assert.NoFileExists(t, c.jarPath(md5Hex([]byte("jar v1"))))
+
+	// 声明的 md5 变化时立即重新下载, 同一个声明错误的 md5 只重新下载一次
+	content.Store("jar v3")
+	jar, err = c.get(upstream.URL+"/spider.jar;md5;"+md5Hex([]byte("jar v3")), false)
+	assert.NoError(t, err)
+	assert.Equal(t, "jar v3", string(jar.Data()))
+	assert.Equal(t, int64(3), requests.Load())
+	content.Store("jar v2")
+	for i := 0; i < 2; i++ {
+		jar, err = c.get(upstream.URL+"/spider.jar;md5;wrong", false)
+		assert.NoError(t, err)
+		assert.Equal(t, "jar v2", string(jar.Data()))
+	}
+	assert.Equal(t, int64(4), requests.Load())
+
+	// 重启后从缓存目录加载, 上游不可用时仍然可以使用
+	upstream.Close()
+	c = newJarCache(dir, nil)
+	jar, err = c.get(upstream.URL+"/spider.jar;md5;"+md5Hex([]byte("jar v2")), false)
+	assert.NoError(t, err)
+	assert.Equal(t, "jar v2", string(jar.Data()))
+
+	// 从未成功下载的地址返回错误, 且在重试间隔内不再请求
+	_, err = c.get(upstream.URL+"/other.jar", false)
+	assert.Error(t, err)
+	_, err = c.get(upstream.URL+"/other.jar", false)
+	assert.Error(t, err)
+}
+
+func TestJarCache_Prune(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer upstream.Close()
+
+	c := newJarCache(t.TempDir(), nil)
+	old, err := c.get(upstream.URL+"/old.jar", false)
+	assert.NoError(t, err)
+
+	// 未缓存时不等待下载
+	assert.Nil(t, c.cached(upstream.URL+"/new.jar", false))
+	assert.Eventually(t, func() bool {
+		return c.cached(upstream.URL+"/new.jar", false) != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// 长时间未使用的地址在下一次下载时移除
+	c.mu.Lock()
+	c.used[upstream.URL+"/old.jar"] = time.Now().Add(-jarUnusedTTL)
+	c.mu.Unlock()
+	_, err = c.get(upstream.URL+"/other.jar", false)
+	assert.NoError(t, err)
+	assert.NotContains(t, c.entries, upstream.URL+"/old.jar")
+	assert.Contains(t, c.entries, upstream.URL+"/new.jar")
+	assert.NoFileExists(t, c.jarPath(old.MD5))
+}
+
+func TestMixRepo_CachedSpider(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config.json":
+			w.Write([]byte(`{"spider":"./spider.jar;md5;outdated"}`))
+		case "/spider.jar":
+			w.Write([]byte("spider"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	sm := NewSourceManager([]config.Source{
+		{Name: "main", URL: upstream.URL + "/config.json", Type: config.SourceTypeSingle},
+	})
+	defer sm.Close()
+
+	cfg := &config.Config{
+		ServerPort: 8080,
+		SingleRepoOpt: config.SingleRepoOpt{
+			Spider: config.MixOpt{SourceName: "main", Field: "spider"},
+		},
+	}
+
+	// 首次混合不等待 jar 下载, 使用上游地址; 下载完成后 generation 变化, 再次混合使用代理地址
+	gen := sm.Generation()
+	result, report, err := MixRepoWithReport(cfg, sm)
+	assert.NoError(t, err)
+	assert.Equal(t, upstream.URL+"/spider.jar;md5;outdated", result.Spider)
+	assert.Empty(t, report.Warnings)
+	assert.Eventually(t, func() bool { return sm.Generation() > gen+1 }, 5*time.Second, 10*time.Millisecond)
+
+	result, report, err = MixRepoWithReport(cfg, sm)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080/v1/spider;md5;"+md5Hex([]byte("spider")), result.Spider)
+	assert.Empty(t, report.Warnings)
+
+	jar, err := ResolveSpiderJar(cfg.SingleRepoOpt.Spider, sm)
+	assert.NoError(t, err)
+	assert.Equal(t, upstream.URL+"/spider.jar", jar.URL)
+
+	// jar 下载失败时使用上游地址
+	sm.jars.mu.Lock()
+	sm.jars.entries[upstream.URL+"/spider.jar"] = &jarEntry{err: assert.AnError, checkedAt: time.Now()}
+	sm.jars.mu.Unlock()
+	result, err = MixRepo(cfg, sm)
+	assert.NoError(t, err)
+	assert.Equal(t, upstream.URL+"/spider.jar;md5;outdated", result.Spider)
+}
+
+func TestMixRepo_SpiderHostHangs(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/spider.jar" {
+			<-release
+		}
+		w.Write([]byte(`{"spider":"./spider.jar"}`))
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	sm := NewSourceManager([]config.Source{
+		{Name: "main", URL: upstream.URL + "/config.json", Type: config.SourceTypeSingle},
+	})
+	defer sm.Close()
+
+	cfg := &config.Config{
+		SingleRepoOpt: config.SingleRepoOpt{
+			Spider: config.MixOpt{SourceName: "main", Field: "spider"},
+		},
+	}
+
+	// jar 的上游无响应时混合不会被阻塞
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		result, err := MixRepo(cfg, sm)
+		assert.NoError(t, err)
+		assert.Equal(t, upstream.URL+"/spider.jar", result.Spider)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("mixing blocked on the spider jar download")
+	}
+}
+
+func TestMixRepo_SiteJarFromOtherSource(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"main": {
+				config: config.Source{Name: "main", URL: "https://example.com/main/config.json"},
+				data:   []byte(`{"spider":"./main.jar","sites":[{"key":"main","type":3,"api":"csp_Main"}]}`),
+			},
+			"other": {
+				config: config.Source{Name: "other", URL: "https://example.com/other/config.json"},
+				data: []byte(`{"spider":"./other.jar;md5;abc","sites":[
+					{"key":"csp","type":3,"api":"csp_Other"},
+					{"key":"own","type":3,"api":"csp_Own","jar":"./own.jar"},
+					{"key":"cms","type":1,"api":"https://example.com/api.php"}
+				]}`),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		SingleRepoOpt: config.SingleRepoOpt{
+			Spider: config.MixOpt{SourceName: "main", Field: "spider"},
+			Sites:  config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "other", Field: "sites"}},
+		},
+	}
+
+	result, err := MixRepo(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/main/main.jar", result.Spider)
+	assert.Equal(t, "https://example.com/other/other.jar;md5;abc", result.Sites[0].Jar)
+	assert.Equal(t, "https://example.com/other/own.jar", result.Sites[1].Jar)
+	assert.Empty(t, result.Sites[2].Jar)
+
+	// 站点与 spider 来自同一个源时不需要指定 jar
+	cfg.SingleRepoOpt.Sites.SourceName = "main"
+	result, err = MixRepo(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Sites[0].Jar)
+}
+
+func TestMixRepo_SiteJarProxied(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/main.json":
+			w.Write([]byte(`{"spider":"./main.jar"}`))
+		case "/other.json":
+			w.Write([]byte(`{"spider":"./other.jar;md5;outdated","sites":[{"key":"csp","type":3,"api":"csp_Other"}]}`))
+		default:
+			w.Write([]byte(r.URL.Path))
+		}
+	}))
+	defer upstream.Close()
+
+	sm := NewSourceManager([]config.Source{
+		{Name: "main", URL: upstream.URL + "/main.json", Type: config.SourceTypeSingle},
+		{Name: "other source", URL: upstream.URL + "/other.json", Type: config.SourceTypeSingle},
+	})
+	defer sm.Close()
+
+	cfg := &config.Config{
+		ServerPort: 8080,
+		SingleRepoOpt: config.SingleRepoOpt{
+			Spider: config.MixOpt{SourceName: "main", Field: "spider"},
+			Sites:  config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "other source", Field: "sites"}},
+		},
+	}
+
+	// 两个 jar 都在后台下载完成后使用代理地址
+	gen := sm.Generation()
+	_, err := MixRepo(cfg, sm)
+	assert.NoError(t, err)
+	var result *config.RepoConfig
+	assert.Eventually(t, func() bool {
+		result, err = MixRepo(cfg, sm)
+		return err == nil && result.Sites[0].Jar != upstream.URL+"/other.jar;md5;outdated" &&
+			result.Spider != upstream.URL+"/main.jar"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Greater(t, sm.Generation(), gen)
+	assert.Equal(t, "http://localhost:8080/v1/spider;md5;"+md5Hex([]byte("/main.jar")), result.Spider)
+	assert.Equal(t, "http://localhost:8080/v1/spider/other%20source;md5;"+md5Hex([]byte("/other.jar")), result.Sites[0].Jar)
+}
@@ -0,0 +1,53 @@
+package mixer
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// StaticPath 为静态目录在代理服务中的路径
+const StaticPath = "/static"
+
+// staticDir 将位于静态目录中的本地文件地址改为代理服务 /static 下的地址,
+// 使 file:// 源中的相对地址可以被客户端访问
+type staticDir struct {
+	root string // 静态目录的绝对路径
+	base string // 静态目录对外访问的地址
+}
+
+func newStaticDir(dir, externalURL string) (*staticDir, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid static_dir: %w", err)
+	}
+	return &staticDir{root: root, base: externalURL + StaticPath}, nil
+}
+
+// url 返回 s 对外访问的地址, 保留 ;md5; 等后缀; s 不是静态目录中的 file:// 地址时保持不变
+func (d *staticDir) url(s string) string {
+	file, ok := strings.CutPrefix(s, "file://")
+	if !ok {
+		return s
+	}
+	file, suffix, _ := strings.Cut(file, ";")
+	if suffix != "" {
+		suffix = ";" + suffix
+	}
+
+	abs, err := filepath.Abs(filepath.FromSlash(file))
+	if err != nil {
+		return s
+	}
+	rel, err := filepath.Rel(d.root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return s
+	}
+
+	segments := strings.Split(filepath.ToSlash(rel), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return d.base + "/" + strings.Join(segments, "/") + suffix
+}
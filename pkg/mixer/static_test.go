@@ -0,0 +1,68 @@
+package mixer
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wayjam/tvbox-mixproxy/config"
+)
+
+func TestMixRepo_StaticDir(t *testing.T) {
+	dir := t.TempDir()
+	staticDir := filepath.Join(dir, "static")
+	data := []byte(`{
+		"spider": "./spider.jar;md5;abc",
+		"sites": [{"key": "site1", "type": 3, "api": "./js/drpy.js", "ext": "../ext/site 1.json"}],
+		"lives": [{"name": "live1", "url": "./live.txt"}],
+		"rules": [{"name": "r1", "hosts": ["a.com"], "script": ["./r1.js"]}]
+	}`)
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"static": {
+				config: config.Source{Name: "static", URL: "file://" + filepath.ToSlash(staticDir) + "/tv/config.json"},
+				data:   data,
+			},
+			"local": {
+				config: config.Source{Name: "local", URL: "file://" + filepath.ToSlash(dir) + "/local/config.json"},
+				data:   data,
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		ExternalURL: "http://box:8080",
+		StaticDir:   staticDir,
+		SingleRepoOpt: config.SingleRepoOpt{
+			Spider: config.MixOpt{SourceName: "static"},
+			Sites:  config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "static"}},
+			Lives:  config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "static"}},
+			Rules:  config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "static"}},
+		},
+	}
+	cfg.Fixture()
+
+	result, err := MixRepo(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://box:8080/static/tv/spider.jar;md5;abc", result.Spider)
+	assert.Equal(t, "http://box:8080/static/tv/js/drpy.js", result.Sites[0].API)
+	assert.Equal(t, "http://box:8080/static/ext/site%201.json", result.Sites[0].Ext)
+	assert.Equal(t, "http://box:8080/static/tv/live.txt", result.Lives[0].URL)
+	assert.Equal(t, []string{"http://box:8080/static/tv/r1.js"}, result.Rules[0].Script)
+
+	// 不在静态目录中的本地文件保持不变, 并记录告警
+	cfg.SingleRepoOpt.Lives.SourceName = "local"
+	result, report, err := MixRepoWithReport(cfg, mockSourcer)
+	assert.NoError(t, err)
+	localLive := "file://" + filepath.ToSlash(dir) + "/local/live.txt"
+	assert.Equal(t, localLive, result.Lives[0].URL)
+	assert.Equal(t, []string{localLive}, report.LocalFiles)
+	assert.Contains(t, report.Warnings, LocalFileWarning(localLive))
+
+	// 未配置静态目录时保持本地路径
+	cfg.StaticDir = ""
+	result, report, err = MixRepoWithReport(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Equal(t, "file://"+filepath.ToSlash(staticDir)+"/tv/spider.jar;md5;abc", result.Spider)
+	assert.Contains(t, report.LocalFiles, result.Spider)
+}
@@ -0,0 +1,139 @@
+package mixer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wayjam/tvbox-mixproxy/config"
+)
+
+// 以下测试用于在 go test -race 下检查 SourceManager 的并发安全性
+
+// fakeUpstream 为本地模拟的上游, 每次请求返回当前版本的配置并记录请求次数
+type fakeUpstream struct {
+	*httptest.Server
+	version  atomic.Int64
+	requests atomic.Int64
+	delay    time.Duration
+}
+
+func newFakeUpstream(t *testing.T, delay time.Duration) *fakeUpstream {
+	u := &fakeUpstream{delay: delay}
+	u.version.Store(1)
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.requests.Add(1)
+		time.Sleep(u.delay)
+		v := u.version.Load()
+		fmt.Fprintf(w, `{"spider":"spider_v%d","sites":[{"key":"site_v%d","name":"Site %d"}]}`, v, v, v)
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+func TestStress_ConcurrentGetSourceFetchesOnce(t *testing.T) {
+	upstream := newFakeUpstream(t, 50*time.Millisecond)
+	sm := NewSourceManager([]config.Source{
+		{Name: "test", URL: upstream.URL, Type: config.SourceTypeSingle, Interval: 3600},
+	})
+	defer sm.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			source, err := sm.GetSource("test")
+			if assert.NoError(t, err) {
+				assert.Contains(t, string(source.Data()), "spider_v1")
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(1), upstream.requests.Load())
+}
+
+func TestStress_MixWhileRefreshing(t *testing.T) {
+	upstream := newFakeUpstream(t, time.Millisecond)
+	sources := []config.Source{
+		{Name: "main", URL: upstream.URL, Type: config.SourceTypeSingle, Interval: 3600},
+		{Name: "backup", URL: upstream.URL + "/backup", Type: config.SourceTypeSingle, Interval: 3600},
+	}
+	sm := NewSourceManager(sources)
+	defer sm.Close()
+
+	cfg := &config.Config{
+		SingleRepoOpt: config.SingleRepoOpt{
+			Spider: config.MixOpt{SourceName: "main"},
+			Sites:  config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "main", Fallbacks: []string{"backup"}}},
+		},
+	}
+	cfg.Fixture()
+
+	var (
+		wg   sync.WaitGroup
+		stop = make(chan struct{})
+	)
+	run := func(fn func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					fn()
+				}
+			}
+		}()
+	}
+
+	// 混合与读取
+	for i := 0; i < 4; i++ {
+		run(func() {
+			repo, err := MixRepo(cfg, sm)
+			if assert.NoError(t, err) {
+				assert.NotEmpty(t, repo.Spider)
+			}
+		})
+	}
+	run(func() {
+		source, err := sm.GetSource("backup")
+		if assert.NoError(t, err) {
+			assert.True(t, json.Valid(source.Data()))
+		}
+	})
+	run(func() {
+		sm.Status()
+		sm.CachedData("main")
+		sm.PreviousData("main")
+		sm.Versions("main")
+		sm.Generation()
+	})
+
+	// 上游变化、强制刷新、配置更新与版本固定
+	run(func() {
+		upstream.version.Add(1)
+		sm.RefreshAll()
+	})
+	run(func() {
+		sm.Update(sources)
+	})
+	run(func() {
+		if _, err := sm.Rollback("main"); err == nil {
+			sm.Unpin("main")
+		}
+	})
+
+	time.Sleep(500 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,348 @@
+package resproxy
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3/log"
+)
+
+const (
+	DefaultTTL         = 10 * time.Minute
+	DefaultMaxSize     = 256 << 20
+	DefaultMaxFileSize = 16 << 20
+
+	fetchTimeout = 30 * time.Second
+	metaSuffix   = ".json"
+)
+
+// ErrTooLarge 表示资源超过单个资源的大小上限, 不做缓存
+var ErrTooLarge = errors.New("resource too large")
+
+// Options 为缓存的配置, 零值字段使用默认值
+type Options struct {
+	TTL         time.Duration     // 缓存有效期, 过期后在后台重新校验, 期间继续返回已缓存的版本
+	MaxSize     int64             // 缓存总大小上限, 超出时淘汰最久未使用的资源
+	MaxFileSize int64             // 单个资源大小上限, 不超过 MaxSize
+	Headers     map[string]string // 请求上游时附加的请求头
+}
+
+func (o Options) withDefaults() Options {
+	if o.TTL <= 0 {
+		o.TTL = DefaultTTL
+	}
+	if o.MaxSize <= 0 {
+		o.MaxSize = DefaultMaxSize
+	}
+	if o.MaxFileSize <= 0 {
+		o.MaxFileSize = DefaultMaxFileSize
+	}
+	// 超过缓存总大小的资源存入后会立即被淘汰, 直接视为过大
+	if o.MaxFileSize > o.MaxSize {
+		o.MaxFileSize = o.MaxSize
+	}
+	return o
+}
+
+// Resource 为缓存的资源, 存入缓存后不再修改
+type Resource struct {
+	URL          string    `json:"url"`
+	ContentType  string    `json:"content_type,omitempty"`
+	ETag         string    `json:"etag,omitempty"`          // 上游返回的 ETag, 用于重新校验
+	LastModified string    `json:"last_modified,omitempty"` // 上游返回的 Last-Modified, 用于重新校验
+	Hash         string    `json:"hash"`                    // 内容的 sha256
+	Size         int64     `json:"size"`
+	FetchedAt    time.Time `json:"fetched_at"` // 最近一次从上游获取或校验的时间
+
+	path string // 磁盘缓存时的数据文件
+	data []byte // 仅保存在内存中时的数据
+}
+
+// Data 返回资源内容, 资源在读取前被淘汰时返回错误
+func (r *Resource) Data() ([]byte, error) {
+	if r.path == "" {
+		return r.data, nil
+	}
+	return os.ReadFile(r.path)
+}
+
+// Cache 以 LRU 方式缓存上游资源, dir 不为空时资源保存在 dir 中, 重启后仍然可用
+type Cache struct {
+	dir    string
+	client *http.Client
+
+	mu    sync.Mutex
+	opts  Options
+	size  int64
+	lru   *list.List               // 最近使用的在前, 元素为 *Resource
+	items map[string]*list.Element // 地址 -> lru 中的元素
+	calls map[string]*fetchCall    // 正在进行的下载, 合并同一地址的并发下载
+}
+
+type fetchCall struct {
+	done chan struct{}
+	res  *Resource
+	err  error
+}
+
+// NewCache 创建资源缓存并加载 cacheDir 中已有的资源, cacheDir 为空时仅保存在内存中
+func NewCache(cacheDir string, opts Options) *Cache {
+	c := &Cache{
+		client: &http.Client{Timeout: fetchTimeout},
+		opts:   opts.withDefaults(),
+		lru:    list.New(),
+		items:  make(map[string]*list.Element),
+		calls:  make(map[string]*fetchCall),
+	}
+	if cacheDir == "" {
+		return c
+	}
+
+	c.dir = filepath.Join(cacheDir, "res")
+	if err := c.load(); err != nil {
+		log.Warnf("loading resource cache: %v", err)
+	}
+	return c
+}
+
+// SetOptions 更新缓存配置, 总大小上限变小时立即淘汰超出的资源
+func (c *Cache) SetOptions(opts Options) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.opts = opts.withDefaults()
+	c.evictLocked()
+}
+
+// load 从缓存目录加载资源, 数据文件缺失或大小不符的资源被忽略, 最近获取的视为最近使用
+func (c *Cache) load() error {
+	files, err := filepath.Glob(filepath.Join(c.dir, "*"+metaSuffix))
+	if err != nil {
+		return err
+	}
+
+	var resources []*Resource
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var res Resource
+		if err := json.Unmarshal(content, &res); err != nil || res.URL == "" {
+			continue
+		}
+		res.path = c.dataPath(res.URL)
+		if info, err := os.Stat(res.path); err != nil || info.Size() != res.Size {
+			continue
+		}
+		resources = append(resources, &res)
+	}
+
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].FetchedAt.After(resources[j].FetchedAt)
+	})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, res := range resources {
+		c.items[res.URL] = c.lru.PushBack(res)
+		c.size += res.Size
+	}
+	c.evictLocked()
+	return nil
+}
+
+// Get 返回 uri 对应的资源, 未缓存时从上游下载.
+// 已缓存的资源过期后在后台重新校验, 期间以及上游失败时继续返回已缓存的版本
+func (c *Cache) Get(uri string) (*Resource, error) {
+	if !strings.HasPrefix(uri, "http://") && !strings.HasPrefix(uri, "https://") {
+		return nil, fmt.Errorf("unsupported url %q", uri)
+	}
+
+	c.mu.Lock()
+	var res *Resource
+	if elem, ok := c.items[uri]; ok {
+		c.lru.MoveToFront(elem)
+		res = elem.Value.(*Resource)
+	}
+	_, fetching := c.calls[uri]
+	stale := res != nil && time.Since(res.FetchedAt) >= c.opts.TTL
+	c.mu.Unlock()
+
+	if res != nil {
+		if stale && !fetching {
+			go c.fetch(uri)
+		}
+		return res, nil
+	}
+	return c.fetch(uri)
+}
+
+// fetch 从上游获取 uri 并更新缓存, 同一地址的并发下载只执行一次
+func (c *Cache) fetch(uri string) (*Resource, error) {
+	c.mu.Lock()
+	if call, ok := c.calls[uri]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.res, call.err
+	}
+	call := &fetchCall{done: make(chan struct{})}
+	c.calls[uri] = call
+	var old *Resource
+	if elem, ok := c.items[uri]; ok {
+		old = elem.Value.(*Resource)
+	}
+	opts := c.opts
+	c.mu.Unlock()
+
+	call.res, call.err = c.download(uri, old, opts)
+	if call.err != nil && old != nil {
+		log.Warnf("revalidating resource %s: %v", uri, call.err)
+	}
+
+	c.mu.Lock()
+	delete(c.calls, uri)
+	c.mu.Unlock()
+	close(call.done)
+	return call.res, call.err
+}
+
+// download 下载 uri, 有旧版本时带上校验信息, 上游未修改时沿用旧版本的内容
+func (c *Cache) download(uri string, old *Resource, opts Options) (*Resource, error) {
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range opts.Headers {
+		req.Header.Set(key, value)
+	}
+	if old != nil {
+		if old.ETag != "" {
+			req.Header.Set("If-None-Match", old.ETag)
+		}
+		if old.LastModified != "" {
+			req.Header.Set("If-Modified-Since", old.LastModified)
+		}
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", uri, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && old != nil {
+		res := *old
+		res.FetchedAt = time.Now()
+		return c.store(&res, nil)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("fetching %s: unexpected status code: %d", uri, resp.StatusCode)
+	}
+	if resp.ContentLength > opts.MaxFileSize {
+		return nil, ErrTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, opts.MaxFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", uri, err)
+	}
+	if int64(len(data)) > opts.MaxFileSize {
+		return nil, ErrTooLarge
+	}
+
+	sum := sha256.Sum256(data)
+	res := &Resource{
+		URL:          uri,
+		ContentType:  resp.Header.Get("Content-Type"),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Hash:         hex.EncodeToString(sum[:]),
+		Size:         int64(len(data)),
+		FetchedAt:    time.Now(),
+	}
+	return c.store(res, data)
+}
+
+// store 保存资源并放入缓存, data 为 nil 时仅更新元信息
+func (c *Cache) store(res *Resource, data []byte) (*Resource, error) {
+	if c.dir == "" {
+		if data != nil {
+			res.data = data
+		}
+	} else {
+		res.path = c.dataPath(res.URL)
+		if err := c.write(res, data); err != nil {
+			return nil, fmt.Errorf("saving %s: %w", res.URL, err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[res.URL]; ok {
+		c.size -= elem.Value.(*Resource).Size
+		c.lru.Remove(elem)
+	}
+	c.items[res.URL] = c.lru.PushFront(res)
+	c.size += res.Size
+	c.evictLocked()
+	return res, nil
+}
+
+// write 将资源的数据与元信息写入缓存目录, data 为 nil 时仅写入元信息
+func (c *Cache) write(res *Resource, data []byte) error {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	if data != nil {
+		if err := writeFile(res.path, data); err != nil {
+			return err
+		}
+	}
+	meta, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	return writeFile(res.path+metaSuffix, meta)
+}
+
+// evictLocked 淘汰最久未使用的资源直到总大小不超过上限, 调用方需持有锁
+func (c *Cache) evictLocked() {
+	for c.size > c.opts.MaxSize {
+		elem := c.lru.Back()
+		if elem == nil {
+			return
+		}
+		res := elem.Value.(*Resource)
+		c.lru.Remove(elem)
+		delete(c.items, res.URL)
+		c.size -= res.Size
+		if res.path != "" {
+			os.Remove(res.path)
+			os.Remove(res.path + metaSuffix)
+		}
+	}
+}
+
+func (c *Cache) dataPath(uri string) string {
+	sum := sha256.Sum256([]byte(uri))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// writeFile 先写入临时文件再重命名, 避免读取到写了一半的文件
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,153 @@
+package resproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSign(t *testing.T) {
+	uri := "https://example.com/ext.json?a=1&b=2"
+	sig := Sign("secret", uri)
+	assert.True(t, Verify("secret", uri, sig))
+	assert.False(t, Verify("other", uri, sig))
+	assert.False(t, Verify("secret", uri+"&c=3", sig))
+	assert.False(t, Verify("", uri, Sign("", uri)))
+
+	proxied, err := url.Parse(URL("http://box:8080", "secret", uri))
+	assert.NoError(t, err)
+	assert.Equal(t, "/v1/res", proxied.Path)
+	assert.Equal(t, uri, proxied.Query().Get("u"))
+	assert.Equal(t, sig, proxied.Query().Get("s"))
+}
+
+func TestCache(t *testing.T) {
+	var (
+		requests atomic.Int64
+		down     atomic.Bool
+		body     atomic.Value
+	)
+	body.Store("v1")
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if down.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, "tvbox", r.Header.Get("User-Agent"))
+		etag := `"` + body.Load().(string) + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body.Load().(string)))
+	}))
+	defer upstream.Close()
+
+	dir := t.TempDir()
+	opts := Options{TTL: time.Hour, Headers: map[string]string{"User-Agent": "tvbox"}}
+	cache := NewCache(dir, opts)
+	uri := upstream.URL + "/ext.json"
+
+	res, err := cache.Get(uri)
+	assert.NoError(t, err)
+	data, err := res.Data()
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", string(data))
+	assert.Equal(t, "application/json", res.ContentType)
+
+	// 有效期内直接使用缓存
+	_, err = cache.Get(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), requests.Load())
+
+	// 重启后从磁盘加载
+	cache = NewCache(dir, opts)
+	res, err = cache.Get(uri)
+	assert.NoError(t, err)
+	data, _ = res.Data()
+	assert.Equal(t, "v1", string(data))
+	assert.Equal(t, int64(1), requests.Load())
+
+	// 过期后在后台重新校验, 上游未修改时沿用缓存内容
+	cache.SetOptions(Options{TTL: time.Nanosecond, Headers: opts.Headers})
+	res, err = cache.fetch(uri)
+	assert.NoError(t, err)
+	data, _ = res.Data()
+	assert.Equal(t, "v1", string(data))
+	assert.Equal(t, int64(2), requests.Load())
+
+	body.Store("v2")
+	res, err = cache.fetch(uri)
+	assert.NoError(t, err)
+	data, _ = res.Data()
+	assert.Equal(t, "v2", string(data))
+
+	// 上游失败时继续返回缓存的版本
+	down.Store(true)
+	_, err = cache.fetch(uri)
+	assert.Error(t, err)
+	res, err = cache.Get(uri)
+	assert.NoError(t, err)
+	data, _ = res.Data()
+	assert.Equal(t, "v2", string(data))
+
+	// 未缓存的资源上游失败时返回错误
+	_, err = cache.Get(upstream.URL + "/other.json")
+	assert.Error(t, err)
+
+	_, err = cache.Get("file:///etc/passwd")
+	assert.Error(t, err)
+}
+
+func TestCache_Limits(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", len(r.URL.Path))))
+	}))
+	defer upstream.Close()
+
+	for _, dir := range []string{"", t.TempDir()} {
+		cache := NewCache(dir, Options{MaxSize: 20, MaxFileSize: 10})
+
+		_, err := cache.Get(upstream.URL + "/too_large_file")
+		assert.ErrorIs(t, err, ErrTooLarge)
+
+		// 超出总大小时淘汰最久未使用的资源
+		a, b, c := upstream.URL+"/aaaaaaa", upstream.URL+"/bbbbbbb", upstream.URL+"/ccccccc"
+		for _, uri := range []string{a, b, a, c} {
+			_, err := cache.Get(uri)
+			assert.NoError(t, err)
+		}
+		assert.Contains(t, cache.items, a)
+		assert.NotContains(t, cache.items, b)
+		assert.Contains(t, cache.items, c)
+		assert.Equal(t, int64(16), cache.size)
+	}
+}
+
+func TestCache_MaxFileSizeClamped(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", len(r.URL.Path))))
+	}))
+	defer upstream.Close()
+
+	// 单个资源上限大于总大小时以总大小为准, 超过总大小的资源不会被缓存
+	cache := NewCache(t.TempDir(), Options{MaxSize: 10, MaxFileSize: 100})
+	assert.Equal(t, int64(10), cache.opts.MaxFileSize)
+
+	_, err := cache.Get(upstream.URL + "/larger_than_max_size")
+	assert.ErrorIs(t, err, ErrTooLarge)
+	assert.Empty(t, cache.items)
+
+	_, err = cache.Get(upstream.URL + "/fits")
+	assert.NoError(t, err)
+	assert.Len(t, cache.items, 1)
+}
@@ -0,0 +1,33 @@
+// Package resproxy 实现 /v1/res 资源代理: 为上游地址签名, 并在磁盘上以 LRU 方式缓存资源
+package resproxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+)
+
+// Path 为资源代理在代理服务中的路径
+const Path = "/v1/res"
+
+// Sign 返回 uri 的签名, 代理只接受签名有效的地址, 避免被用作开放代理
+func Sign(secret, uri string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(uri))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Verify 校验 uri 的签名
+func Verify(secret, uri, sig string) bool {
+	if secret == "" || uri == "" {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, uri)), []byte(sig))
+}
+
+// URL 返回经 base 下的资源代理访问 uri 的签名地址
+func URL(base, secret, uri string) string {
+	query := url.Values{"u": {uri}, "s": {Sign(secret, uri)}}
+	return base + Path + "?" + query.Encode()
+}
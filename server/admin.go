@@ -0,0 +1,125 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/wayjam/tvbox-mixproxy/pkg/mixer"
+)
+
+// AdminTokenHeader 为管理接口令牌的请求头, 也可以使用 Authorization: Bearer <token>
+const AdminTokenHeader = "X-Admin-Token"
+
+// NewAdminAuthMiddleware 校验管理接口令牌, 未配置令牌时禁用所有管理接口
+func NewAdminAuthMiddleware(getConfig ConfigFunc) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		token := getConfig().AdminToken
+		if token == "" {
+			return c.Status(fiber.StatusForbidden).SendString("admin api is disabled, set admin_token to enable it")
+		}
+
+		provided := c.Get(AdminTokenHeader)
+		if provided == "" {
+			provided = strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			return c.Status(fiber.StatusUnauthorized).SendString("invalid admin token")
+		}
+
+		return c.Next()
+	}
+}
+
+// NewRefreshSourceHandler 立即刷新指定源, 忽略更新间隔与退避时间; 已禁用的源返回 409
+func NewRefreshSourceHandler(sourceManager *mixer.SourceManager) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		name := c.Params("name")
+		if err := sourceManager.Refresh(name); err != nil {
+			if errors.Is(err, mixer.ErrSourceDisabled) {
+				return c.Status(fiber.StatusConflict).SendString(err.Error())
+			}
+			if _, statusErr := sourceManager.SourceStatus(name); statusErr != nil {
+				return sourceError(c, statusErr)
+			}
+			return c.Status(fiber.StatusBadGateway).SendString(err.Error())
+		}
+
+		return sourceStatus(c, sourceManager, name)
+	}
+}
+
+// NewRefreshAllSourcesHandler 立即刷新所有未禁用的源
+func NewRefreshAllSourcesHandler(sourceManager *mixer.SourceManager) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		errs := sourceManager.RefreshAll()
+		if len(errs) > 0 {
+			c.Status(fiber.StatusMultiStatus)
+		}
+
+		return c.JSON(sourceManager.Status())
+	}
+}
+
+// NewSetSourceDisabledHandler 在运行时启用或禁用指定源
+func NewSetSourceDisabledHandler(sourceManager *mixer.SourceManager, disabled bool) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		name := c.Params("name")
+		if err := sourceManager.SetDisabled(name, disabled); err != nil {
+			return sourceError(c, err)
+		}
+
+		return sourceStatus(c, sourceManager, name)
+	}
+}
+
+// NewPinSourceHandler 将指定源固定为 version 参数指定的历史版本, 支持 hash 前缀
+func NewPinSourceHandler(sourceManager *mixer.SourceManager) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		name := c.Params("name")
+		version := c.Query("version")
+		if version == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("version is required")
+		}
+		if _, err := sourceManager.Pin(name, version); err != nil {
+			return sourceError(c, err)
+		}
+
+		return sourceStatus(c, sourceManager, name)
+	}
+}
+
+// NewRollbackSourceHandler 将指定源固定为当前版本的上一个版本
+func NewRollbackSourceHandler(sourceManager *mixer.SourceManager) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		name := c.Params("name")
+		if _, err := sourceManager.Rollback(name); err != nil {
+			return sourceError(c, err)
+		}
+
+		return sourceStatus(c, sourceManager, name)
+	}
+}
+
+// NewUnpinSourceHandler 取消固定版本, 恢复使用最新版本
+func NewUnpinSourceHandler(sourceManager *mixer.SourceManager) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		name := c.Params("name")
+		if err := sourceManager.Unpin(name); err != nil {
+			return sourceError(c, err)
+		}
+
+		return sourceStatus(c, sourceManager, name)
+	}
+}
+
+func sourceStatus(c fiber.Ctx, sourceManager *mixer.SourceManager, name string) error {
+	status, err := sourceManager.SourceStatus(name)
+	if err != nil {
+		return sourceError(c, err)
+	}
+
+	return c.JSON(status)
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/log"
+	"github.com/valyala/fasthttp"
+
+	"github.com/wayjam/tvbox-mixproxy/config"
+	"github.com/wayjam/tvbox-mixproxy/pkg/mixer"
+)
+
+// mixFunc 按配置混合出结果
+type mixFunc func(cfg *config.Config) (any, *mixer.MixReport, error)
+
+// mixedResponse 为预先序列化与压缩的混合结果
+type mixedResponse struct {
+	body     []byte
+	gzip     []byte
+	brotli   []byte
+	etag     string // 未压缩内容的 ETag, 压缩后的内容在引号内加上 -gzip 或 -br 后缀
+	servedBy string
+	warnings string
+}
+
+// mixCache 缓存混合结果, 配置重新加载或源数据变化后失效.
+// 读取缓存不需要加锁, 缓存失效时同一配置与版本的并发请求只混合一次
+type mixCache struct {
+	mix        mixFunc
+	generation func() uint64
+
+	current atomic.Pointer[mixEntry]
+
+	mu   sync.Mutex
+	call *mixCall // 正在进行的混合
+}
+
+// mixEntry 为某个配置与源数据版本对应的混合结果
+type mixEntry struct {
+	cfg  *config.Config
+	gen  uint64
+	resp *mixedResponse
+}
+
+type mixCall struct {
+	cfg  *config.Config
+	gen  uint64
+	done chan struct{}
+	resp *mixedResponse
+	err  error
+}
+
+func newMixCache(mix mixFunc, generation func() uint64) *mixCache {
+	return &mixCache{mix: mix, generation: generation}
+}
+
+// get 返回当前配置与源数据对应的混合结果, 缓存失效时重新混合
+func (m *mixCache) get(cfg *config.Config) (*mixedResponse, error) {
+	// 先记录版本号再混合, 混合过程中源数据发生变化时下一次请求会重新混合
+	gen := m.generation()
+	if current := m.current.Load(); current != nil && current.cfg == cfg && current.gen == gen {
+		return current.resp, nil
+	}
+
+	m.mu.Lock()
+	if call := m.call; call != nil && call.cfg == cfg && call.gen == gen {
+		m.mu.Unlock()
+		<-call.done
+		return call.resp, call.err
+	}
+	call := &mixCall{cfg: cfg, gen: gen, done: make(chan struct{})}
+	m.call = call
+	m.mu.Unlock()
+
+	call.resp, call.err = m.remix(cfg)
+	if call.err == nil {
+		m.current.Store(&mixEntry{cfg: cfg, gen: gen, resp: call.resp})
+	}
+
+	m.mu.Lock()
+	if m.call == call {
+		m.call = nil
+	}
+	m.mu.Unlock()
+	close(call.done)
+	return call.resp, call.err
+}
+
+func (m *mixCache) remix(cfg *config.Config) (*mixedResponse, error) {
+	result, report, err := m.mix(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newMixedResponse(result, report)
+}
+
+func newMixedResponse(result any, report *mixer.MixReport) (*mixedResponse, error) {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(body)
+	resp := &mixedResponse{
+		body:   body,
+		gzip:   fasthttp.AppendGzipBytes(nil, body),
+		brotli: fasthttp.AppendBrotliBytes(nil, body),
+		etag:   `"` + hex.EncodeToString(sum[:16]) + `"`,
+	}
+
+	if len(report.ServedBy) > 0 {
+		fields := make([]string, 0, len(report.ServedBy))
+		for field, source := range report.ServedBy {
+			fields = append(fields, field+"="+source)
+		}
+		sort.Strings(fields)
+		resp.servedBy = strings.Join(fields, ", ")
+	}
+
+	// 告警信息只在重新混合时记录一次
+	for _, warning := range report.Warnings {
+		log.Warnf("mix: %s", warning)
+	}
+	resp.warnings = headerValue(strings.Join(report.Warnings, "; "), maxWarningsHeaderLen)
+
+	return resp, nil
+}
+
+// maxWarningsHeaderLen 为告警响应头的长度上限, 完整的告警信息见日志
+const maxWarningsHeaderLen = 1024
+
+// headerValue 将告警等来自上游的文本转为可以放入响应头的值:
+// 控制字符 (包括 CR/LF) 替换为空格, 超过 max 字节时截断并以 ... 结尾
+func headerValue(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, strings.ToValidUTF8(s, ""))
+	if len(s) <= max {
+		return s
+	}
+
+	s = s[:max-3]
+	// 避免截断在多字节字符中间
+	for !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s + "..."
+}
+
+// send 发送混合结果, 支持 If-None-Match 与 gzip/brotli 压缩
+func (r *mixedResponse) send(c fiber.Ctx) error {
+	if r.servedBy != "" {
+		c.Set(MixServedByHeader, r.servedBy)
+	}
+	if r.warnings != "" {
+		c.Set(MixWarningsHeader, r.warnings)
+	}
+	c.Set(fiber.HeaderVary, fiber.HeaderAcceptEncoding)
+
+	// 不同编码的内容不同, 各自使用不同的 ETag
+	body, encoding := r.body, ""
+	header := &c.Request().Header
+	switch {
+	case header.HasAcceptEncoding("br"):
+		body, encoding = r.brotli, "br"
+	case header.HasAcceptEncoding("gzip"):
+		body, encoding = r.gzip, "gzip"
+	}
+	etag := r.etag
+	if encoding != "" {
+		etag = strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+	}
+	c.Set(fiber.HeaderETag, etag)
+
+	if etagMatch(c.Get(fiber.HeaderIfNoneMatch), etag) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if encoding != "" {
+		c.Set(fiber.HeaderContentEncoding, encoding)
+	}
+	return c.Send(body)
+}
+
+// etagMatch 判断 If-None-Match 是否包含 etag, 按弱比较处理
+func etagMatch(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/wayjam/tvbox-mixproxy/config"
+	"github.com/wayjam/tvbox-mixproxy/pkg/mixer"
+)
+
+func TestMixCache(t *testing.T) {
+	var (
+		mixCount   atomic.Int64
+		generation atomic.Uint64
+		cfg        = &config.Config{}
+	)
+	cache := newMixCache(func(cfg *config.Config) (any, *mixer.MixReport, error) {
+		mixCount.Add(1)
+		return map[string]int{"generation": int(generation.Load())}, &mixer.MixReport{}, nil
+	}, generation.Load)
+
+	app := fiber.New()
+	app.Get("/", func(c fiber.Ctx) error {
+		resp, err := cache.get(cfg)
+		if err != nil {
+			return err
+		}
+		return resp.send(c)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, `{"generation":0}`, string(body))
+	etag := resp.Header.Get(fiber.HeaderETag)
+	assert.NotEmpty(t, etag)
+
+	// 源数据未变化时使用缓存并支持 If-None-Match
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(fiber.HeaderIfNoneMatch, etag)
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotModified, resp.StatusCode)
+	assert.Equal(t, int64(1), mixCount.Load())
+
+	// 压缩后的内容使用不同的 ETag
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(fiber.HeaderAcceptEncoding, "gzip")
+	req.Header.Set(fiber.HeaderIfNoneMatch, etag)
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, "gzip", resp.Header.Get(fiber.HeaderContentEncoding))
+	assert.Equal(t, fiber.HeaderAcceptEncoding, resp.Header.Get(fiber.HeaderVary))
+	gzipETag := resp.Header.Get(fiber.HeaderETag)
+	assert.Equal(t, strings.TrimSuffix(etag, `"`)+`-gzip"`, gzipETag)
+	reader, err := gzip.NewReader(resp.Body)
+	assert.NoError(t, err)
+	body, _ = io.ReadAll(reader)
+	assert.Equal(t, `{"generation":0}`, string(body))
+	assert.Equal(t, int64(1), mixCount.Load())
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(fiber.HeaderAcceptEncoding, "gzip")
+	req.Header.Set(fiber.HeaderIfNoneMatch, gzipETag)
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotModified, resp.StatusCode)
+
+	// 源数据变化后重新混合
+	generation.Add(1)
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(fiber.HeaderIfNoneMatch, etag)
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.NotEqual(t, etag, resp.Header.Get(fiber.HeaderETag))
+	assert.Equal(t, int64(2), mixCount.Load())
+
+	// 配置重新加载后重新混合
+	cfg = &config.Config{}
+	_, err = app.Test(httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), mixCount.Load())
+}
+
+func TestMixCache_Concurrent(t *testing.T) {
+	var (
+		mixCount atomic.Int64
+		release  = make(chan struct{})
+		cfg      = &config.Config{}
+	)
+	cache := newMixCache(func(cfg *config.Config) (any, *mixer.MixReport, error) {
+		mixCount.Add(1)
+		<-release
+		return map[string]int{}, &mixer.MixReport{}, nil
+	}, func() uint64 { return 0 })
+
+	// 缓存失效时并发请求只混合一次
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := cache.get(cfg)
+			assert.NoError(t, err)
+			assert.NotNil(t, resp)
+		}()
+	}
+	assert.Eventually(t, func() bool { return mixCount.Load() == 1 }, 5*time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int64(1), mixCount.Load())
+
+	_, err := cache.get(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), mixCount.Load())
+}
+
+func TestHeaderValue(t *testing.T) {
+	assert.Equal(t, "a b  c", headerValue("a\nb\r\nc", 100))
+	assert.Equal(t, "abcdefg...", headerValue(strings.Repeat("abcdefg", 3), 10))
+	// 不截断在多字节字符中间
+	assert.Equal(t, "ab...", headerValue("ab中文", 7))
+	assert.Equal(t, "ab中...", headerValue("ab中文字", 9))
+}
@@ -1,13 +1,28 @@
 package server
 
 import (
+	"errors"
 	"image/png"
-	"strconv"
+	"net/http"
+	"net/url"
 
 	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/log"
 	"github.com/wayjam/tvbox-mixproxy/config"
+	"github.com/wayjam/tvbox-mixproxy/pkg/diff"
 	"github.com/wayjam/tvbox-mixproxy/pkg/imageutil"
 	"github.com/wayjam/tvbox-mixproxy/pkg/mixer"
+	"github.com/wayjam/tvbox-mixproxy/pkg/resproxy"
+)
+
+// ConfigFunc 返回当前生效的配置, 配置重新加载后返回新的配置
+type ConfigFunc func() *config.Config
+
+const (
+	// MixWarningsHeader 为 best-effort 模式下返回告警信息的响应头
+	MixWarningsHeader = "X-MixProxy-Warnings"
+	// MixServedByHeader 为返回各字段实际来源的响应头
+	MixServedByHeader = "X-MixProxy-Served-By"
 )
 
 func Home(c fiber.Ctx) error {
@@ -15,39 +30,15 @@ func Home(c fiber.Ctx) error {
 }
 
 func Logo(c fiber.Ctx) error {
-	svgLogo := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="100" viewBox="0 0 100 100">
-		<rect x="10" y="20" width="80" height="60" rx="5" ry="5" fill="#333"/>
-		<rect x="15" y="25" width="70" height="50" rx="3" ry="3" fill="#4CAF50"/>
-		<circle cx="50" cy="75" r="5" fill="#333"/>
-		<line x1="30" y1="15" x2="40" y2="5" stroke="#333" stroke-width="2"/>
-		<line x1="70" y1="15" x2="60" y2="5" stroke="#333" stroke-width="2"/>
-	</svg>`
 	c.Set("Content-Type", "image/svg+xml")
-	return c.SendString(svgLogo)
+	return c.SendString(imageutil.LogoSVG)
 }
 
 func Wallpaper(c fiber.Ctx) error {
 	// Parse query parameters
-	width, _ := strconv.Atoi(c.Query("width", "800"))
-	height, _ := strconv.Atoi(c.Query("height", "600"))
-	pattern := c.Query("pattern", "solid")
-	opacity, _ := strconv.ParseFloat(c.Query("opacity", "1.0"), 64)
-	borderWidth, _ := strconv.Atoi(c.Query("border_width", "0"))
-
-	// Parse colors
-	bgColor := imageutil.ParseColor(c.Query("bg_color", "FFFFFF"))
-	borderColor := imageutil.ParseColor(c.Query("border_color", "000000"))
-
-	// Create image parameters
-	params := imageutil.ImageParams{
-		BackgroundColor: bgColor,
-		Width:           width,
-		Height:          height,
-		Pattern:         pattern,
-		Opacity:         opacity,
-		BorderWidth:     borderWidth,
-		BorderColor:     borderColor,
-	}
+	params := imageutil.ParseImageParams(func(key, def string) string {
+		return c.Query(key, def)
+	})
 
 	// Generate the image
 	img := imageutil.GenerateImage(params)
@@ -57,43 +48,245 @@ func Wallpaper(c fiber.Ctx) error {
 	return png.Encode(c.Response().BodyWriter(), img)
 }
 
-func NewRepoHandler(cfg *config.Config, sourceManager *mixer.SourceManager) fiber.Handler {
+// NewRepoHandler 返回混合后的单仓配置, 结果会被缓存直到配置重新加载或源数据变化
+func NewRepoHandler(getConfig ConfigFunc, sourceManager *mixer.SourceManager) fiber.Handler {
+	cache := newMixCache(func(cfg *config.Config) (any, *mixer.MixReport, error) {
+		return mixer.MixRepoWithReport(cfg, sourceManager)
+	}, sourceManager.Generation)
+
 	return func(c fiber.Ctx) error {
+		cfg := getConfig()
 		if cfg.SingleRepoOpt.Disable {
 			return c.Status(fiber.StatusNotImplemented).SendString("SingleRepo is disabled")
 		}
 
-		result, err := mixer.MixRepo(cfg, sourceManager)
+		resp, err := cache.get(cfg)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 		}
 
-		return c.JSON(result)
+		return resp.send(c)
 	}
 }
 
-func NewMultiRepoHandler(cfg *config.Config, sourceManager *mixer.SourceManager) fiber.Handler {
+// NewMultiRepoHandler 返回混合后的多仓配置, 结果会被缓存直到配置重新加载或源数据变化
+func NewMultiRepoHandler(getConfig ConfigFunc, sourceManager *mixer.SourceManager) fiber.Handler {
+	cache := newMixCache(func(cfg *config.Config) (any, *mixer.MixReport, error) {
+		return mixer.MixMultiRepoWithReport(cfg, sourceManager)
+	}, sourceManager.Generation)
+
 	return func(c fiber.Ctx) error {
+		cfg := getConfig()
 		if cfg.MultiRepoOpt.Disable {
 			return c.Status(fiber.StatusNotImplemented).SendString("MultiRepo is disabled")
 		}
 
-		result, err := mixer.MixMultiRepo(cfg, sourceManager)
+		resp, err := cache.get(cfg)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 		}
 
-		return c.JSON(result)
+		return resp.send(c)
 	}
 }
 
-func NewSpiderHandler(cfg *config.Config, sourceManager *mixer.SourceManager) fiber.Handler {
-	handler, err := mixer.NewMixURLHandler(cfg.SingleRepoOpt.Spider, sourceManager)
-	if err != nil {
-		return func(c fiber.Ctx) error {
+// NewSourcesHandler 返回所有源的状态, 单仓源同时列出 spider jar 中找不到对应类的 csp 站点
+func NewSourcesHandler(sourceManager *mixer.SourceManager) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		statuses := sourceManager.Status()
+		reports := make([]sourceReport, 0, len(statuses))
+		for _, status := range statuses {
+			issues, _ := sourceManager.CheckSites(status.Name, false)
+			reports = append(reports, sourceReport{SourceStatus: status, SiteIssues: issues})
+		}
+		return c.JSON(reports)
+	}
+}
+
+// sourceReport 为源的状态以及其中 spider 类不存在的 csp 站点
+type sourceReport struct {
+	mixer.SourceStatus
+	SiteIssues []mixer.SiteIssue `json:"site_issues,omitempty"`
+}
+
+// NewSourceDataHandler 返回指定源当前缓存的原始数据
+func NewSourceDataHandler(sourceManager *mixer.SourceManager) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		data, err := sourceManager.CachedData(c.Params("name"))
+		if err != nil {
+			return sourceError(c, err)
+		}
+		if data == nil {
+			return c.Status(fiber.StatusServiceUnavailable).SendString("source has not been fetched yet")
+		}
+
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+		return c.Send(data)
+	}
+}
+
+// NewSourceDiffHandler 返回指定源两个版本之间的差异, from 与 to 为历史版本的 hash 或其前缀,
+// 默认比较上一个版本与当前版本. 默认输出文本, format=json 时输出 JSON
+func NewSourceDiffHandler(sourceManager *mixer.SourceManager) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		name := c.Params("name")
+		current, err := sourceManager.CachedData(name)
+		if err != nil {
+			return sourceError(c, err)
+		}
+		if current == nil && (c.Query("from") == "" || c.Query("to") == "") {
+			return c.Status(fiber.StatusServiceUnavailable).SendString("source has not been fetched yet")
+		}
+
+		to := current
+		if version := c.Query("to"); version != "" {
+			if to, err = sourceManager.VersionData(name, version); err != nil {
+				return sourceError(c, err)
+			}
+		}
+
+		var from []byte
+		if version := c.Query("from"); version != "" {
+			if from, err = sourceManager.VersionData(name, version); err != nil {
+				return sourceError(c, err)
+			}
+		} else {
+			// 默认使用保存的历史中的上一个版本, 重启后仍然可用
+			versions, err := sourceManager.Versions(name)
+			if err != nil {
+				return sourceError(c, err)
+			}
+			if len(versions) < 2 {
+				return c.Status(fiber.StatusNotFound).SendString("source has no previous version")
+			}
+			if from, err = sourceManager.VersionData(name, versions[1].Hash); err != nil {
+				return sourceError(c, err)
+			}
+		}
+
+		result, err := diff.Data(from, to)
+		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 		}
+
+		if c.Query("format") == "json" {
+			return c.JSON(result)
+		}
+		return c.SendString(result.String())
+	}
+}
+
+// NewSourceVersionsHandler 返回指定源保留的历史版本
+func NewSourceVersionsHandler(sourceManager *mixer.SourceManager) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		versions, err := sourceManager.Versions(c.Params("name"))
+		if err != nil {
+			return sourceError(c, err)
+		}
+
+		return c.JSON(versions)
+	}
+}
+
+// sourceError 根据错误类型返回对应的状态码
+func sourceError(c fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, mixer.ErrSourceNotFound), errors.Is(err, mixer.ErrVersionNotFound):
+		return c.Status(fiber.StatusNotFound).SendString(err.Error())
+	case errors.Is(err, mixer.ErrAmbiguousVersion):
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+}
+
+// NewSpiderHandler 返回单仓的 spider jar, 每次请求时按当前配置与源数据解析地址.
+// 优先使用缓存的 jar, 无法缓存时直接代理上游
+func NewSpiderHandler(getConfig ConfigFunc, sourceManager *mixer.SourceManager) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		return serveSpider(c, getConfig().SingleRepoOpt.Spider, sourceManager)
+	}
+}
+
+// NewSourceSpiderHandler 返回指定单仓源中 spider 字段对应的 jar, 处理方式与 NewSpiderHandler 相同,
+// 供来自不同源的站点通过 jar 字段引用
+func NewSourceSpiderHandler(sourceManager *mixer.SourceManager) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		name, err := url.PathUnescape(c.Params("source_name"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		status, err := sourceManager.SourceStatus(name)
+		if err != nil {
+			return sourceError(c, err)
+		}
+		if status.Type != config.SourceTypeSingle {
+			return c.Status(fiber.StatusBadRequest).SendString("source " + name + " should be a single source")
+		}
+		return serveSpider(c, config.MixOpt{SourceName: name, Field: "spider"}, sourceManager)
+	}
+}
+
+// serveSpider 优先返回缓存的 jar, 无法缓存时直接代理上游
+func serveSpider(c fiber.Ctx, spiderOpt config.MixOpt, sourceManager *mixer.SourceManager) error {
+	jar, err := mixer.ResolveSpiderJar(spiderOpt, sourceManager)
+	if err != nil {
+		log.Warnf("serving cached spider: %v, proxying upstream", err)
+	}
+	if jar == nil {
+		return mixer.NewMixURLHandler(spiderOpt, sourceManager)(c)
+	}
+	return sendJar(c, jar)
+}
+
+// sendJar 返回缓存的 jar, ETag 为内容的 md5, 客户端可以通过 If-None-Match 校验缓存
+func sendJar(c fiber.Ctx, jar *mixer.SpiderJar) error {
+	etag := `"` + jar.MD5 + `"`
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderLastModified, jar.FetchedAt.UTC().Format(http.TimeFormat))
+	c.Set(fiber.HeaderCacheControl, "public, no-cache")
+	if etagMatch(c.Get(fiber.HeaderIfNoneMatch), etag) {
+		return c.SendStatus(fiber.StatusNotModified)
 	}
 
-	return handler
+	c.Set(fiber.HeaderContentType, "application/java-archive")
+	return c.Send(jar.Data())
+}
+
+// NewResHandler 代理并缓存混合结果中引用的资源, 只接受带有有效签名的地址.
+// 资源超过大小上限或缓存读取失败时重定向到上游
+func NewResHandler(getConfig ConfigFunc, cache *resproxy.Cache) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		opt := getConfig().ResProxy
+		if !opt.Enable {
+			return c.SendStatus(fiber.StatusNotFound)
+		}
+		uri := c.Query("u")
+		if !resproxy.Verify(opt.Secret, uri, c.Query("s")) {
+			return c.Status(fiber.StatusForbidden).SendString("invalid signature")
+		}
+
+		res, err := cache.Get(uri)
+		if errors.Is(err, resproxy.ErrTooLarge) {
+			return c.Redirect().To(uri)
+		}
+		if err != nil {
+			return c.Status(fiber.StatusBadGateway).SendString(err.Error())
+		}
+		data, err := res.Data()
+		if err != nil {
+			log.Warnf("reading cached resource %s: %v, redirecting upstream", uri, err)
+			return c.Redirect().To(uri)
+		}
+
+		etag := `"` + res.Hash + `"`
+		c.Set(fiber.HeaderETag, etag)
+		c.Set(fiber.HeaderCacheControl, "public, no-cache")
+		if etagMatch(c.Get(fiber.HeaderIfNoneMatch), etag) {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+		if res.ContentType != "" {
+			c.Set(fiber.HeaderContentType, res.ContentType)
+		}
+		return c.Send(data)
+	}
 }
@@ -0,0 +1,419 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/wayjam/tvbox-mixproxy/config"
+	"github.com/wayjam/tvbox-mixproxy/pkg/mixer"
+	"github.com/wayjam/tvbox-mixproxy/pkg/resproxy"
+)
+
+func TestSpiderHandler_FollowsSourceRefresh(t *testing.T) {
+	var version atomic.Int64
+	version.Store(1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config.json":
+			fmt.Fprintf(w, `{"spider":"./jar/spider_v%d.jar;md5;abc"}`, version.Load())
+		default:
+			fmt.Fprint(w, r.URL.Path)
+		}
+	}))
+	defer upstream.Close()
+
+	sm := mixer.NewSourceManager([]config.Source{
+		{Name: "main", URL: upstream.URL + "/config.json", Type: config.SourceTypeSingle, Interval: 3600},
+	})
+	defer sm.Close()
+
+	cfg := &config.Config{
+		SingleRepoOpt: config.SingleRepoOpt{
+			Spider: config.MixOpt{SourceName: "main", Field: "spider"},
+		},
+	}
+	app := fiber.New()
+	app.Get("/v1/spider", NewSpiderHandler(func() *config.Config { return cfg }, sm))
+
+	get := func() string {
+		resp, err := app.Test(httptest.NewRequest("GET", "/v1/spider", nil))
+		if !assert.NoError(t, err) {
+			return ""
+		}
+		defer resp.Body.Close()
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	assert.Equal(t, "/jar/spider_v1.jar", get())
+
+	// 源刷新后 spider 地址变化, 代理随之切换
+	version.Store(2)
+	assert.NoError(t, sm.Refresh("main"))
+	assert.Equal(t, "/jar/spider_v2.jar", get())
+
+	// 配置变更同样即时生效
+	cfg = &config.Config{SingleRepoOpt: config.SingleRepoOpt{Spider: config.MixOpt{Disabled: true}}}
+	assert.Empty(t, get())
+}
+
+func TestSpiderHandler_CachedJar(t *testing.T) {
+	var requests atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config.json":
+			fmt.Fprint(w, `{"spider":"./spider.jar"}`)
+		default:
+			requests.Add(1)
+			fmt.Fprint(w, "spider")
+		}
+	}))
+	defer upstream.Close()
+
+	sm := mixer.NewSourceManager([]config.Source{
+		{Name: "main", URL: upstream.URL + "/config.json", Type: config.SourceTypeSingle},
+	})
+	defer sm.Close()
+
+	cfg := &config.Config{
+		SingleRepoOpt: config.SingleRepoOpt{
+			Spider: config.MixOpt{SourceName: "main", Field: "spider"},
+		},
+	}
+	app := fiber.New()
+	app.Get("/v1/spider", NewSpiderHandler(func() *config.Config { return cfg }, sm))
+
+	// md5("spider")
+	etag := `"f1a81d782dea6a19bdca383bffe68452"`
+	for i := 0; i < 3; i++ {
+		resp, err := app.Test(httptest.NewRequest("GET", "/v1/spider", nil))
+		assert.NoError(t, err)
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+		assert.Equal(t, etag, resp.Header.Get(fiber.HeaderETag))
+		assert.Equal(t, "application/java-archive", resp.Header.Get(fiber.HeaderContentType))
+		assert.NotEmpty(t, resp.Header.Get(fiber.HeaderLastModified))
+	}
+	assert.Equal(t, int64(1), requests.Load())
+
+	req := httptest.NewRequest("GET", "/v1/spider", nil)
+	req.Header.Set(fiber.HeaderIfNoneMatch, etag)
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotModified, resp.StatusCode)
+}
+
+func TestSourceSpiderHandler(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/single.json":
+			fmt.Fprint(w, `{"spider":"./single.jar"}`)
+		case "/multi.json":
+			fmt.Fprint(w, `{"urls":[]}`)
+		default:
+			fmt.Fprint(w, r.URL.Path)
+		}
+	}))
+	defer upstream.Close()
+
+	sm := mixer.NewSourceManager([]config.Source{
+		{Name: "single source", URL: upstream.URL + "/single.json", Type: config.SourceTypeSingle},
+		{Name: "multi", URL: upstream.URL + "/multi.json", Type: config.SourceTypeMulti},
+	})
+	defer sm.Close()
+
+	app := fiber.New()
+	app.Get("/v1/spider/:source_name", NewSourceSpiderHandler(sm))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/v1/spider/single%20source", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "/single.jar", string(body))
+	assert.NotEmpty(t, resp.Header.Get(fiber.HeaderETag))
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/v1/spider/multi", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/v1/spider/unknown", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+}
+
+func TestSourceSpiderHandler_LocalFile(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret.jar")
+	assert.NoError(t, os.WriteFile(secret, []byte("secret"), 0644))
+	local := filepath.Join(dir, "local.json")
+	assert.NoError(t, os.WriteFile(local, []byte(`{"spider":"./secret.jar"}`), 0644))
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"spider":"file://%s"}`, filepath.ToSlash(secret))
+	}))
+	defer upstream.Close()
+
+	sm := mixer.NewSourceManager([]config.Source{
+		{Name: "remote", URL: upstream.URL + "/config.json", Type: config.SourceTypeSingle},
+		{Name: "local", URL: "file://" + filepath.ToSlash(local), Type: config.SourceTypeSingle},
+	})
+	defer sm.Close()
+
+	app := fiber.New()
+	app.Get("/v1/spider/:source_name", NewSourceSpiderHandler(sm))
+
+	// 网络源中的 file:// 地址不读取服务器上的文件
+	resp, err := app.Test(httptest.NewRequest("GET", "/v1/spider/remote", nil))
+	assert.NoError(t, err)
+	assert.NotEqual(t, fiber.StatusOK, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.NotContains(t, string(body), "secret")
+
+	source, err := sm.GetSource("remote")
+	assert.NoError(t, err)
+	jar, err := sm.SpiderJar("file://"+filepath.ToSlash(secret), source)
+	assert.NoError(t, err)
+	assert.Nil(t, jar)
+
+	// 本地源可以引用本地的 jar
+	resp, err = app.Test(httptest.NewRequest("GET", "/v1/spider/local", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, "secret", string(body))
+}
+
+func TestSourcesHandler_SiteIssues(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config.json":
+			fmt.Fprint(w, `{"spider":"./spider.jar","sites":[{"key":"gone","type":3,"api":"csp_Gone"}]}`)
+		default:
+			// 空的 jar
+			w.Write([]byte("PK\x05\x06" + strings.Repeat("\x00", 18)))
+		}
+	}))
+	defer upstream.Close()
+
+	sm := mixer.NewSourceManager([]config.Source{
+		{Name: "main", URL: upstream.URL + "/config.json", Type: config.SourceTypeSingle},
+	})
+	defer sm.Close()
+	_, err := sm.GetSource("main")
+	assert.NoError(t, err)
+
+	app := fiber.New()
+	app.Get("/v1/sources", NewSourcesHandler(sm))
+
+	type report struct {
+		Name       string            `json:"name"`
+		Hash       string            `json:"hash"`
+		SiteIssues []mixer.SiteIssue `json:"site_issues"`
+	}
+	get := func() []report {
+		resp, err := app.Test(httptest.NewRequest("GET", "/v1/sources", nil))
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		var reports []report
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&reports))
+		return reports
+	}
+
+	// 状态接口不等待 jar 下载, jar 在后台缓存后才检查使用它的站点
+	var reports []report
+	assert.Eventually(t, func() bool {
+		reports = get()
+		return len(reports) == 1 && len(reports[0].SiteIssues) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	if assert.Len(t, reports, 1) {
+		assert.Equal(t, "main", reports[0].Name)
+		assert.NotEmpty(t, reports[0].Hash)
+		if assert.Len(t, reports[0].SiteIssues, 1) {
+			assert.Equal(t, "gone", reports[0].SiteIssues[0].Key)
+		}
+	}
+}
+
+func TestSourceDiffHandler(t *testing.T) {
+	var version atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"sites":[{"key":"site%d","name":"Site"}]}`, version.Load())
+	}))
+	defer upstream.Close()
+
+	sources := []config.Source{
+		{Name: "main", URL: upstream.URL, Type: config.SourceTypeSingle, Interval: 3600},
+	}
+	cacheDir := t.TempDir()
+	sm := mixer.NewSourceManager(sources, mixer.WithCacheDir(cacheDir))
+	defer sm.Close()
+
+	app := fiber.New()
+	app.Get("/v1/sources/:name/diff", NewSourceDiffHandler(sm))
+	get := func(query string) (int, string) {
+		resp, err := app.Test(httptest.NewRequest("GET", "/v1/sources/main/diff"+query, nil))
+		if !assert.NoError(t, err) {
+			return 0, ""
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	// 只有一个版本时没有可比较的上一个版本
+	version.Store(1)
+	assert.NoError(t, sm.Refresh("main"))
+	status, _ := get("")
+	assert.Equal(t, fiber.StatusNotFound, status)
+
+	for i := 2; i <= 3; i++ {
+		version.Store(int64(i))
+		assert.NoError(t, sm.Refresh("main"))
+	}
+	versions, err := sm.Versions("main")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 3)
+
+	// 默认比较上一个版本与当前版本
+	status, body := get("")
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Contains(t, body, "site2")
+	assert.Contains(t, body, "site3")
+
+	// 比较任意两个历史版本, 版本可以是 hash 的前缀
+	status, body = get("?from=" + versions[2].Hash[:12] + "&to=" + versions[1].Hash)
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Contains(t, body, "site1")
+	assert.Contains(t, body, "site2")
+	assert.NotContains(t, body, "site3")
+
+	status, body = get("?from=" + versions[2].Hash)
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Contains(t, body, "site1")
+	assert.Contains(t, body, "site3")
+
+	status, _ = get("?from=ffffffff")
+	assert.Equal(t, fiber.StatusNotFound, status)
+
+	// 重启后默认使用缓存目录中保存的上一个版本
+	restarted := mixer.NewSourceManager(sources, mixer.WithCacheDir(cacheDir))
+	defer restarted.Close()
+	app = fiber.New()
+	app.Get("/v1/sources/:name/diff", NewSourceDiffHandler(restarted))
+	status, body = get("")
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Contains(t, body, "site2")
+	assert.Contains(t, body, "site3")
+}
+
+func TestResHandler(t *testing.T) {
+	var requests atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/large.txt":
+			w.Write([]byte(strings.Repeat("x", 2<<20)))
+			return
+		case "/missing":
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "#EXTM3U")
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{ResProxy: config.ResProxyOpt{Enable: true, Secret: "secret", MaxFileSize: 1}}
+	cache := resproxy.NewCache(t.TempDir(), resProxyOptions(cfg))
+	app := fiber.New()
+	app.Get("/v1/res", NewResHandler(func() *config.Config { return cfg }, cache))
+
+	get := func(target string, header ...string) *http.Response {
+		req := httptest.NewRequest("GET", target, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		return resp
+	}
+	live := upstream.URL + "/live.m3u"
+
+	resp := get(resproxy.URL("", "secret", live))
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/plain", resp.Header.Get(fiber.HeaderContentType))
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "#EXTM3U", string(body))
+
+	etag := resp.Header.Get(fiber.HeaderETag)
+	resp = get(resproxy.URL("", "secret", live), fiber.HeaderIfNoneMatch, etag)
+	assert.Equal(t, fiber.StatusNotModified, resp.StatusCode)
+	assert.Equal(t, int64(1), requests.Load())
+
+	// 签名无效时拒绝
+	resp = get(resproxy.URL("", "other", live))
+	assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+
+	// 超过大小上限时重定向到上游
+	resp = get(resproxy.URL("", "secret", upstream.URL+"/large.txt"))
+	assert.Equal(t, fiber.StatusFound, resp.StatusCode)
+	assert.Equal(t, upstream.URL+"/large.txt", resp.Header.Get(fiber.HeaderLocation))
+
+	resp = get(resproxy.URL("", "secret", upstream.URL+"/missing"))
+	assert.Equal(t, fiber.StatusBadGateway, resp.StatusCode)
+
+	cfg = &config.Config{}
+	resp = get(resproxy.URL("", "secret", live))
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+}
+
+func TestStaticRoutes(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "main", "files"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "main", "files", "live.txt"), []byte("#EXTM3U"), 0644))
+
+	staticDir := filepath.Join(dir, "static")
+	assert.NoError(t, os.MkdirAll(staticDir, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(staticDir, "ext.json"), []byte("{}"), 0644))
+
+	s := NewServer(&config.Config{MirrorDir: dir, StaticDir: staticDir, Log: config.LogOpt{Level: 4}})
+	defer s.sourceManager.Close()
+	s.SetupRoutes(s.app)
+
+	resp, err := s.app.Test(httptest.NewRequest("GET", "/mirror/main/files/live.txt", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "#EXTM3U", string(body))
+
+	resp, err = s.app.Test(httptest.NewRequest("GET", "/mirror/main/files/missing.txt", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+
+	resp, err = s.app.Test(httptest.NewRequest("GET", "/static/ext.json", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, "{}", string(body))
+
+	// 未配置 mirror_dir 时不提供 /mirror
+	s = NewServer(&config.Config{Log: config.LogOpt{Level: 4}})
+	defer s.sourceManager.Close()
+	s.SetupRoutes(s.app)
+
+	resp, err = s.app.Test(httptest.NewRequest("GET", "/mirror/main/files/live.txt", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/wayjam/tvbox-mixproxy/pkg/mixer"
+)
+
+// Readiness 为就绪检查的结果
+type Readiness struct {
+	Ready      bool     `json:"ready"`
+	Prefetched bool     `json:"prefetched"`        // 启动时的预取是否已结束
+	Mixed      bool     `json:"mixed"`             // 是否已成功混合过单仓或多仓
+	Pending    []string `json:"pending,omitempty"` // 尚未获取到数据的源, 不影响是否就绪
+}
+
+// Healthz 存活检查, 进程能处理请求即返回 200
+func Healthz(c fiber.Ctx) error {
+	return c.SendString("ok")
+}
+
+// NewReadyzHandler 就绪检查, 启动预取结束且至少成功混合过一次时返回 200, 否则返回 503.
+// 个别源持续失败时 best-effort 模式仍可提供服务, 因此不要求所有源都有数据
+func NewReadyzHandler(prefetched, mixed func() bool, sourceManager *mixer.SourceManager) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		readiness := Readiness{Prefetched: prefetched(), Mixed: mixed()}
+		for _, status := range sourceManager.Status() {
+			if !status.Disabled && status.Hash == "" {
+				readiness.Pending = append(readiness.Pending, status.Name)
+			}
+		}
+		readiness.Ready = readiness.Prefetched && readiness.Mixed
+
+		if !readiness.Ready {
+			c.Status(fiber.StatusServiceUnavailable)
+		}
+		return c.JSON(readiness)
+	}
+}
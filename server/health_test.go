@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/wayjam/tvbox-mixproxy/config"
+	"github.com/wayjam/tvbox-mixproxy/pkg/mixer"
+)
+
+func TestReadyz(t *testing.T) {
+	upstream := httptest.NewServer(nil)
+	defer upstream.Close()
+
+	sm := mixer.NewSourceManager([]config.Source{
+		{Name: "test", URL: upstream.URL + "/not-found"},
+	})
+	defer sm.Close()
+
+	var prefetched, mixed atomic.Bool
+	app := fiber.New()
+	app.Get("/healthz", Healthz)
+	app.Get("/readyz", NewReadyzHandler(prefetched.Load, mixed.Load, sm))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/healthz", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	// 预取未结束
+	resp, err = app.Test(httptest.NewRequest("GET", "/readyz", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusServiceUnavailable, resp.StatusCode)
+
+	// 预取结束但还没有成功混合过
+	prefetched.Store(true)
+	resp, err = app.Test(httptest.NewRequest("GET", "/readyz", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusServiceUnavailable, resp.StatusCode)
+
+	// 成功混合后即使有源持续失败也已就绪, 失败的源仍在 pending 中列出
+	mixed.Store(true)
+	resp, err = app.Test(httptest.NewRequest("GET", "/readyz", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var readiness Readiness
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&readiness))
+	assert.Equal(t, []string{"test"}, readiness.Pending)
+}
+
+func TestServerMarkMixed(t *testing.T) {
+	s := NewServer(&config.Config{
+		Sources:      []config.Source{{Name: "test", URL: "http://127.0.0.1:0/unreachable"}},
+		BestEffort:   true,
+		MultiRepoOpt: config.MultiRepoOpt{Disable: true},
+		Log:          config.LogOpt{Level: 4},
+	})
+	defer s.sourceManager.Close()
+	s.SetupRoutes(s.app)
+
+	resp, err := s.app.Test(httptest.NewRequest("GET", "/v1/multi_repo", nil))
+	assert.NoError(t, err)
+	assert.NotEqual(t, fiber.StatusOK, resp.StatusCode)
+	assert.False(t, s.mixed.Load())
+
+	// best-effort 模式下源失败时仍能成功混合
+	resp, err = s.app.Test(httptest.NewRequest("GET", "/v1/repo", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.True(t, s.mixed.Load())
+}
@@ -1,25 +1,45 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gofiber/fiber/v3"
 	fiberlog "github.com/gofiber/fiber/v3/log"
 	"github.com/gofiber/fiber/v3/middleware/logger"
 	recoverer "github.com/gofiber/fiber/v3/middleware/recover"
 	"github.com/gofiber/fiber/v3/middleware/requestid"
+	"github.com/gofiber/fiber/v3/middleware/static"
 	"gopkg.in/natefinch/lumberjack.v2"
 
 	"github.com/wayjam/tvbox-mixproxy/config"
+	"github.com/wayjam/tvbox-mixproxy/pkg/mirror"
 	"github.com/wayjam/tvbox-mixproxy/pkg/mixer"
+	"github.com/wayjam/tvbox-mixproxy/pkg/resproxy"
+)
+
+const (
+	// defaultPrefetchTimeout 为未配置 prefetch.timeout 时启动预取的超时时间
+	defaultPrefetchTimeout = 30 * time.Second
+	// defaultShutdownTimeout 为未配置 shutdown.timeout 时优雅退出的等待时间
+	defaultShutdownTimeout = 15 * time.Second
 )
 
 type server struct {
 	app           *fiber.App
-	cfg           *config.Config
+	cfg           atomic.Pointer[config.Config]
 	sourceManager *mixer.SourceManager
+	resCache      *resproxy.Cache // 资源代理的缓存
+	reloadMu      sync.Mutex
+	prefetched    atomic.Bool // 启动时的预取是否已结束
+	mixed         atomic.Bool // 是否已成功混合过单仓或多仓
+	logCloser     io.Closer   // 日志输出到文件时用于关闭日志文件
 }
 
 func NewServer(cfg *config.Config) *server {
@@ -31,16 +51,20 @@ func NewServer(cfg *config.Config) *server {
 	app.Use(requestid.New())
 
 	// Configure logging middleware
-	var logOutput io.Writer
+	var (
+		logOutput io.Writer
+		logCloser io.Closer
+	)
 	if cfg.Log.Output == "stdout" || cfg.Log.Output == "" {
 		logOutput = os.Stdout
 	} else {
-		logOutput = &lumberjack.Logger{
+		logFile := &lumberjack.Logger{
 			Filename:   cfg.Log.Output,
 			MaxSize:    100, // megabytes
 			MaxBackups: 3,
 			MaxAge:     28, // days
 		}
+		logOutput, logCloser = logFile, logFile
 	}
 
 	// Set up custom logger format
@@ -54,45 +78,210 @@ func NewServer(cfg *config.Config) *server {
 		TimeZone:   "Local",
 	}))
 
-	sourceManager := mixer.NewSourceManager(cfg.Sources)
+	sourceManager := mixer.NewSourceManager(cfg.Sources,
+		mixer.WithCacheDir(cfg.CacheDir),
+		mixer.WithHistorySize(cfg.HistorySize),
+	)
 
-	return &server{
+	s := &server{
 		app:           app,
-		cfg:           cfg,
 		sourceManager: sourceManager,
+		resCache:      resproxy.NewCache(cfg.CacheDir, resProxyOptions(cfg)),
+		logCloser:     logCloser,
 	}
+	s.cfg.Store(cfg)
+
+	return s
+}
+
+// resProxyOptions 将配置转换为资源缓存的选项, 未配置的项使用默认值
+func resProxyOptions(cfg *config.Config) resproxy.Options {
+	opt := cfg.ResProxy
+	return resproxy.Options{
+		TTL:         time.Duration(opt.TTL) * time.Second,
+		MaxSize:     int64(opt.MaxSize) << 20,
+		MaxFileSize: int64(opt.MaxFileSize) << 20,
+		Headers:     opt.Headers,
+	}
+}
+
+// config 返回当前生效的配置
+func (s *server) config() *config.Config {
+	return s.cfg.Load()
+}
+
+// ReloadConfig 原子地替换当前配置, 并增量更新源管理器.
+// 端口与日志输出位置的变更需要重启后才能生效
+func (s *server) ReloadConfig(cfg *config.Config) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	old := s.config()
+	if old.ServerPort != cfg.ServerPort {
+		fiberlog.Warnf("server_port changed from %d to %d, restart to take effect", old.ServerPort, cfg.ServerPort)
+	}
+	if old.Log.Output != cfg.Log.Output {
+		fiberlog.Warnf("log.output changed from %q to %q, restart to take effect", old.Log.Output, cfg.Log.Output)
+	}
+	if old.CacheDir != cfg.CacheDir || old.HistorySize != cfg.HistorySize {
+		fiberlog.Warnf("cache_dir or history_size changed, restart to take effect")
+	}
+	if old.MirrorDir != cfg.MirrorDir || old.StaticDir != cfg.StaticDir {
+		fiberlog.Warnf("mirror_dir or static_dir changed, restart to take effect")
+	}
+	fiberlog.SetLevel(fiberlog.Level(cfg.Log.Level))
+
+	s.sourceManager.Update(cfg.Sources)
+	s.resCache.SetOptions(resProxyOptions(cfg))
+	s.cfg.Store(cfg)
+
+	fiberlog.Info("config reloaded")
 }
 
 func (s *server) SetupRoutes(app *fiber.App) {
 	app.Get("/", Home)
+	app.Get("/healthz", Healthz)
+	app.Get("/readyz", NewReadyzHandler(s.prefetched.Load, s.mixed.Load, s.sourceManager))
 	app.Get("/logo", Logo)
 	app.Get("/wallpaper", Wallpaper)
+	if dir := s.config().MirrorDir; dir != "" {
+		app.Get(mirror.Path+"/*", static.New(dir))
+	}
+	if dir := s.config().StaticDir; dir != "" {
+		app.Get(mixer.StaticPath+"/*", static.New(dir))
+	}
 
 	v1 := app.Group("/v1")
-	v1.Get("/repo", NewRepoHandler(s.cfg, s.sourceManager))
-	v1.Get("/multi_repo", NewMultiRepoHandler(s.cfg, s.sourceManager))
-	v1.Get("/spider", NewSpiderHandler(s.cfg, s.sourceManager))
+	// 中间件在处理函数之前执行
+	v1.Get("/repo", NewRepoHandler(s.config, s.sourceManager), s.markMixed)
+	v1.Get("/multi_repo", NewMultiRepoHandler(s.config, s.sourceManager), s.markMixed)
+	v1.Get("/spider", NewSpiderHandler(s.config, s.sourceManager))
+	v1.Get("/spider/:source_name", NewSourceSpiderHandler(s.sourceManager))
+	v1.Get("/res", NewResHandler(s.config, s.resCache))
+	v1.Get("/sources", NewSourcesHandler(s.sourceManager))
+	v1.Get("/sources/:name", NewSourceDataHandler(s.sourceManager))
+	v1.Get("/sources/:name/diff", NewSourceDiffHandler(s.sourceManager))
+	v1.Get("/sources/:name/versions", NewSourceVersionsHandler(s.sourceManager))
+
+	admin := v1.Group("/admin", NewAdminAuthMiddleware(s.config))
+	admin.Post("/sources/refresh", NewRefreshAllSourcesHandler(s.sourceManager))
+	admin.Post("/sources/:name/refresh", NewRefreshSourceHandler(s.sourceManager))
+	admin.Post("/sources/:name/enable", NewSetSourceDisabledHandler(s.sourceManager, false))
+	admin.Post("/sources/:name/disable", NewSetSourceDisabledHandler(s.sourceManager, true))
+	admin.Post("/sources/:name/pin", NewPinSourceHandler(s.sourceManager))
+	admin.Post("/sources/:name/rollback", NewRollbackSourceHandler(s.sourceManager))
+	admin.Post("/sources/:name/unpin", NewUnpinSourceHandler(s.sourceManager))
 }
 
+// Run 开始监听, 同时在后台并发预取所有源, 预取结束前就绪检查返回 503
 func (s *server) Run() error {
-	if !s.cfg.SingleRepoOpt.Disable {
-		// Try MixRepo
-		_, err := mixer.MixRepo(s.cfg, s.sourceManager)
-		if err != nil {
-			return fmt.Errorf("failed to initialize MixRepo: %w", err)
+	cfg := s.config()
+
+	s.SetupRoutes(s.app)
+	go s.prefetch(cfg)
+
+	return s.app.Listen(fmt.Sprintf(":%d", cfg.ServerPort))
+}
+
+// ShutdownTimeout 返回优雅退出的等待时间
+func (s *server) ShutdownTimeout() time.Duration {
+	if timeout := s.config().Shutdown.Timeout; timeout > 0 {
+		return time.Duration(timeout) * time.Second
+	}
+	return defaultShutdownTimeout
+}
+
+// Shutdown 优雅退出: 停止接收新连接并等待处理中的请求完成, 然后停止源的刷新,
+// 等待正在进行的获取写入缓存, 最后关闭日志文件. ctx 结束时不再等待
+func (s *server) Shutdown(ctx context.Context) error {
+	var errs []error
+	if err := s.app.ShutdownWithContext(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("shutting down http server: %w", err))
+	}
+	if err := s.sourceManager.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	fiberlog.Info("server stopped")
+	if s.logCloser != nil {
+		if err := s.logCloser.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing log file: %w", err))
 		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// markMixed 在混合接口成功响应后记录已成功混合, 用于就绪检查
+func (s *server) markMixed(c fiber.Ctx) error {
+	err := c.Next()
+	if status := c.Response().StatusCode(); err == nil && (status == fiber.StatusOK || status == fiber.StatusNotModified) {
+		s.mixed.Store(true)
+	}
+	return err
+}
+
+// prefetch 在超时时间内并发获取所有源并检查混合结果, 超时后不再等待, 混合在后台继续进行
+func (s *server) prefetch(cfg *config.Config) {
+	defer s.prefetched.Store(true)
 
+	timeout := time.Duration(cfg.Prefetch.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultPrefetchTimeout
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	if !s.cfg.MultiRepoOpt.Disable {
-		// Try MixMultiRepo
-		_, err := mixer.MixMultiRepo(s.cfg, s.sourceManager)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.warmUp(ctx, cfg)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		fiberlog.Warnf("prefetch did not finish within %s, continuing in background", timeout)
+	}
+}
+
+// warmUp 预取所有源并混合一次单仓与多仓, 任意一个混合成功即视为已成功混合
+func (s *server) warmUp(ctx context.Context, cfg *config.Config) {
+	start := time.Now()
+	errs := s.sourceManager.Prefetch(ctx, cfg.Prefetch.Concurrency)
+	for name, err := range errs {
+		fiberlog.Warnf("prefetching source %s: %v", name, err)
+	}
+	fiberlog.Infof("prefetched %d sources in %s, %d failed or pending",
+		len(cfg.Sources), time.Since(start).Round(time.Millisecond), len(errs))
+
+	if cfg.SingleRepoOpt.Disable && cfg.MultiRepoOpt.Disable {
+		// 没有需要混合的结果
+		s.mixed.Store(true)
+		return
+	}
+
+	if !cfg.SingleRepoOpt.Disable {
+		_, report, err := mixer.MixRepoWithReport(cfg, s.sourceManager)
 		if err != nil {
-			return fmt.Errorf("failed to initialize MixMultiRepo: %w", err)
+			fiberlog.Errorf("failed to mix single repo: %v", err)
+		} else {
+			s.mixed.Store(true)
+			for _, warning := range report.Warnings {
+				fiberlog.Warnf("mix single repo: %s", warning)
+			}
 		}
 	}
 
-	s.SetupRoutes(s.app)
-
-	return s.app.Listen(fmt.Sprintf(":%d", s.cfg.ServerPort))
+	if !cfg.MultiRepoOpt.Disable {
+		_, report, err := mixer.MixMultiRepoWithReport(cfg, s.sourceManager)
+		if err != nil {
+			fiberlog.Errorf("failed to mix multi repo: %v", err)
+		} else {
+			s.mixed.Store(true)
+			for _, warning := range report.Warnings {
+				fiberlog.Warnf("mix multi repo: %s", warning)
+			}
+		}
+	}
 }